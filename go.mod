@@ -2,7 +2,10 @@ module github.com/ad/mcp-filesystem
 
 go 1.24
 
-require github.com/mark3labs/mcp-go v0.32.0
+require (
+	github.com/mark3labs/mcp-go v0.32.0
+	golang.org/x/sys v0.9.0
+)
 
 require (
 	github.com/google/uuid v1.6.0 // indirect
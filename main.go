@@ -56,7 +56,9 @@ func makeHandleWriteFile(allowedDirs []string) func(context.Context, mcp.CallToo
 			log.Printf("[MCP][ERROR] write_file: %v", err)
 			return nil, err
 		}
-		res, err := tools.WriteFile(params, allowedDirs)
+		res, err := tools.Journaled(sessionID(ctx), "write_file", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.WriteFile(params, allowedDirs)
+		})
 		if err != nil {
 			log.Printf("[MCP][ERROR] write_file: %v", err)
 			return nil, err
@@ -73,7 +75,9 @@ func makeHandleCreateDirectory(allowedDirs []string) func(context.Context, mcp.C
 			log.Printf("[MCP][ERROR] create_directory: %v", err)
 			return nil, err
 		}
-		res, err := tools.CreateDirectory(params, allowedDirs)
+		res, err := tools.Journaled(sessionID(ctx), "create_directory", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.CreateDirectory(params, allowedDirs)
+		})
 		if err != nil {
 			log.Printf("[MCP][ERROR] create_directory: %v", err)
 			return nil, err
@@ -107,7 +111,9 @@ func makeHandleMoveFile(allowedDirs []string) func(context.Context, mcp.CallTool
 			log.Printf("[MCP][ERROR] move_file: %v", err)
 			return nil, err
 		}
-		res, err := tools.MoveFile(params, allowedDirs)
+		res, err := tools.Journaled(sessionID(ctx), "move_file", movePaths(params), allowedDirs, func() (tools.ToolResult, error) {
+			return tools.MoveFile(params, allowedDirs)
+		})
 		if err != nil {
 			log.Printf("[MCP][ERROR] move_file: %v", err)
 			return nil, err
@@ -124,7 +130,9 @@ func makeHandleDeleteFile(allowedDirs []string) func(context.Context, mcp.CallTo
 			log.Printf("[MCP][ERROR] delete_file: %v", err)
 			return nil, err
 		}
-		res, err := tools.DeleteFile(params, allowedDirs)
+		res, err := tools.Journaled(sessionID(ctx), "delete_file", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.DeleteFile(params, allowedDirs)
+		})
 		if err != nil {
 			log.Printf("[MCP][ERROR] delete_file: %v", err)
 			return nil, err
@@ -187,7 +195,9 @@ func makeHandleEditFile(allowedDirs []string) func(context.Context, mcp.CallTool
 			log.Printf("[MCP][ERROR] edit_file: %v", err)
 			return nil, err
 		}
-		res, err := tools.EditFile(params, allowedDirs)
+		res, err := tools.Journaled(sessionID(ctx), "edit_file", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.EditFile(params, allowedDirs)
+		})
 		if err != nil {
 			log.Printf("[MCP][ERROR] edit_file: %v", err)
 			return nil, err
@@ -230,14 +240,505 @@ func makeHandleDirectoryTree(allowedDirs []string) func(context.Context, mcp.Cal
 	}
 }
 
+func makeHandleListTrash(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] list_trash: %v", request.Params.Arguments)
+		var params tools.ListTrashParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] list_trash: %v", err)
+			return nil, err
+		}
+		res, err := tools.ListTrash(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] list_trash: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleRestoreFromTrash(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] restore_from_trash: %v", request.Params.Arguments)
+		var params tools.RestoreFromTrashParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] restore_from_trash: %v", err)
+			return nil, err
+		}
+		res, err := tools.RestoreFromTrash(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] restore_from_trash: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleEmptyTrash(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] empty_trash: %v", request.Params.Arguments)
+		var params tools.EmptyTrashParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] empty_trash: %v", err)
+			return nil, err
+		}
+		res, err := tools.EmptyTrash(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] empty_trash: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleCopyFile(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] copy_file: %v", request.Params.Arguments)
+		var params tools.CopyFileParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] copy_file: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "copy_file", []string{params.Destination}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.CopyFile(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] copy_file: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleCopyDirectory(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] copy_directory: %v", request.Params.Arguments)
+		var params tools.CopyDirectoryParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] copy_directory: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "copy_directory", []string{params.Destination}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.CopyDirectory(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] copy_directory: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleBatch(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] batch: %v", request.Params.Arguments)
+		var params tools.BatchParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] batch: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "batch", batchPaths(params), allowedDirs, func() (tools.ToolResult, error) {
+			return tools.Batch(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] batch: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleUndo(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] undo: %v", request.Params.Arguments)
+		var params tools.UndoParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] undo: %v", err)
+			return nil, err
+		}
+		res, err := tools.Undo(params, sessionID(ctx), allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] undo: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleRedo(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] redo: %v", request.Params.Arguments)
+		var params tools.RedoParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] redo: %v", err)
+			return nil, err
+		}
+		res, err := tools.Redo(params, sessionID(ctx), allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] redo: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+// sessionID returns the MCP session of a request so each client gets its own undo journal.
+func sessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return tools.DefaultSession
+}
+
+func movePaths(params tools.MoveFileParams) []string {
+	paths := []string{params.Source, params.Destination}
+	for _, item := range params.Items {
+		paths = append(paths, item.Source, item.Destination)
+	}
+	return paths
+}
+
+func batchPaths(params tools.BatchParams) []string {
+	var paths []string
+	for _, op := range params.Operations {
+		paths = append(paths, op.Path, op.Source, op.Destination)
+	}
+	return paths
+}
+
+func makeHandleNormalizeLineEndings(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] normalize_line_endings: %v", request.Params.Arguments)
+		var params tools.NormalizeLineEndingsParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] normalize_line_endings: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "normalize_line_endings", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.NormalizeLineEndings(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] normalize_line_endings: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleSetPermissions(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] set_permissions: %v", request.Params.Arguments)
+		var params tools.SetPermissionsParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] set_permissions: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "set_permissions", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.SetPermissions(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] set_permissions: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleSetOwner(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] set_owner: %v", request.Params.Arguments)
+		var params tools.SetOwnerParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] set_owner: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "set_owner", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.SetOwner(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] set_owner: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleSetTimes(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] set_times: %v", request.Params.Arguments)
+		var params tools.SetTimesParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] set_times: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "set_times", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.SetTimes(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] set_times: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleCreateSymlink(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] create_symlink: %v", request.Params.Arguments)
+		var params tools.CreateSymlinkParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] create_symlink: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "create_symlink", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.CreateSymlink(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] create_symlink: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleCreateHardlink(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] create_hardlink: %v", request.Params.Arguments)
+		var params tools.CreateHardlinkParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] create_hardlink: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "create_hardlink", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.CreateHardlink(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] create_hardlink: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleReadLink(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] read_link: %v", request.Params.Arguments)
+		var params tools.ReadLinkParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] read_link: %v", err)
+			return nil, err
+		}
+		res, err := tools.ReadLink(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] read_link: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleApplyPatch(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] apply_patch: %v", request.Params.Arguments)
+		var params tools.ApplyPatchParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] apply_patch: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "apply_patch", tools.PatchPaths(params.Patch), allowedDirs, func() (tools.ToolResult, error) {
+			return tools.ApplyPatch(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] apply_patch: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleReplaceInFiles(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] replace_in_files: %v", request.Params.Arguments)
+		var params tools.ReplaceInFilesParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] replace_in_files: %v", err)
+			return nil, err
+		}
+		// Plan once: the journal snapshots exactly the files the plan writes.
+		plan, err := tools.PlanReplaceInFiles(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] replace_in_files: %v", err)
+			return nil, err
+		}
+		var paths []string
+		if !params.DryRun {
+			paths = plan.Paths()
+		}
+		res, err := tools.Journaled(sessionID(ctx), "replace_in_files", paths, allowedDirs, func() (tools.ToolResult, error) {
+			return plan.Apply(allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] replace_in_files: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleJSONGet(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] json_get: %v", request.Params.Arguments)
+		var params tools.JSONGetParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] json_get: %v", err)
+			return nil, err
+		}
+		res, err := tools.JSONGet(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] json_get: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleJSONSet(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] json_set: %v", request.Params.Arguments)
+		var params tools.JSONSetParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] json_set: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "json_set", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.JSONSet(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] json_set: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleJSONDelete(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] json_delete: %v", request.Params.Arguments)
+		var params tools.JSONDeleteParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] json_delete: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "json_delete", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.JSONDelete(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] json_delete: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleJSONPatch(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] json_patch: %v", request.Params.Arguments)
+		var params tools.JSONPatchParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] json_patch: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "json_patch", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.JSONPatch(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] json_patch: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleFormatFile(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] format_file: %v", request.Params.Arguments)
+		var params tools.FormatFileParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] format_file: %v", err)
+			return nil, err
+		}
+		res, err := tools.Journaled(sessionID(ctx), "format_file", []string{params.Path}, allowedDirs, func() (tools.ToolResult, error) {
+			return tools.FormatFile(params, allowedDirs)
+		})
+		if err != nil {
+			log.Printf("[MCP][ERROR] format_file: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleGrepFiles(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] grep_files: %v", request.Params.Arguments)
+		var params tools.GrepFilesParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] grep_files: %v", err)
+			return nil, err
+		}
+		res, err := tools.GrepFiles(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] grep_files: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+func makeHandleFindFiles(allowedDirs []string) func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		log.Printf("[MCP] find_files: %v", request.Params.Arguments)
+		var params tools.FindFilesParams
+		if err := decodeParams(request.Params.Arguments, &params); err != nil {
+			log.Printf("[MCP][ERROR] find_files: %v", err)
+			return nil, err
+		}
+		res, err := tools.FindFiles(params, allowedDirs)
+		if err != nil {
+			log.Printf("[MCP][ERROR] find_files: %v", err)
+			return nil, err
+		}
+		return wrapResult(res), nil
+	}
+}
+
+// decodeParams fills out, a pointer to a parameter struct, from the tool
+// arguments after checking them against the struct's schema.
 func decodeParams(args interface{}, out interface{}) error {
 	b, err := json.Marshal(args)
 	if err != nil {
 		return err
 	}
+	var raw interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	if err := tools.ValidateArgs(raw, out); err != nil {
+		return err
+	}
 	return json.Unmarshal(b, out)
 }
 
+// newTool describes a tool whose input schema is generated from params, a
+// zero value of its parameter struct.
+func newTool(name, description string, params interface{}) mcp.Tool {
+	schema, err := tools.InputSchema(params)
+	if err != nil {
+		log.Fatalf("schema for %s: %v", name, err)
+	}
+	return mcp.NewToolWithRawSchema(name, description, schema)
+}
+
 func wrapResult(res tools.ToolResult) *mcp.CallToolResult {
 	isError := false
 	if v, ok := res["isError"]; ok {
@@ -260,145 +761,444 @@ func wrapResult(res tools.ToolResult) *mcp.CallToolResult {
 func main() {
 	var transport = flag.String("transport", "stdio", "Transport type: stdio, sse, or http")
 	var port = flag.String("port", "8080", "Port for SSE/HTTP servers")
+	defaults := tools.DefaultPolicy()
+	var allowPermanentDelete = flag.Bool("allow-permanent-delete", defaults.AllowPermanentDelete, "Allow delete_file to bypass the trash with permanent: true")
+	var trashMaxAge = flag.Duration("trash-max-age", defaults.TrashMaxAge, "Purge trashed entries older than this (0 keeps them forever)")
+	var trashMaxSize = flag.Int64("trash-max-size", defaults.TrashMaxSize, "Maximum trash size in bytes per root (0 means unlimited)")
+	var allowChown = flag.Bool("allow-chown", defaults.AllowChown, "Allow set_owner to change file ownership")
+	var maxDeleteEntries = flag.Int("max-delete-entries", defaults.MaxDeleteEntries, "Maximum number of entries a single delete may remove (0 means unlimited)")
+	var syntaxCheck = flag.String("syntax-check", "", "Comma-separated extensions (.go, .json, .xml) to parse before write_file, edit_file and apply_patch write them")
+	var syntaxCheckMode = flag.String("syntax-check-mode", "block", "What to do with files that fail the syntax check: block or warn")
+	var formatOnWrite = flag.String("format-on-write", "", "Comma-separated extensions (.go, .json, .xml) that write_file and edit_file format before writing")
+	var readOnly = flag.String("read-only", "", "Comma-separated allowed directories that tools may read but not change")
 	flag.Parse()
 
+	syntaxCheckExtensions, err := tools.ParseSyntaxCheckExtensions(*syntaxCheck)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -syntax-check: %v\n", err)
+		os.Exit(1)
+	}
+	if *syntaxCheckMode != "block" && *syntaxCheckMode != "warn" {
+		fmt.Fprintf(os.Stderr, "Invalid -syntax-check-mode %q (expected block or warn)\n", *syntaxCheckMode)
+		os.Exit(1)
+	}
+	formatOnWriteExtensions, err := tools.ParseFormatExtensions(*formatOnWrite)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -format-on-write: %v\n", err)
+		os.Exit(1)
+	}
+	readOnlyRoots, err := tools.ParseReadOnlyRoots(*readOnly, flag.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -read-only: %v\n", err)
+		os.Exit(1)
+	}
+
+	tools.SetPolicy(tools.Policy{
+		AllowPermanentDelete:    *allowPermanentDelete,
+		TrashMaxAge:             *trashMaxAge,
+		TrashMaxSize:            *trashMaxSize,
+		MaxDeleteEntries:        *maxDeleteEntries,
+		AllowChown:              *allowChown,
+		SyntaxCheckExtensions:   syntaxCheckExtensions,
+		SyntaxCheckWarnOnly:     *syntaxCheckMode == "warn",
+		FormatOnWriteExtensions: formatOnWriteExtensions,
+		ReadOnlyRoots:           readOnlyRoots,
+	})
+
 	allowedDirs := flag.Args()
 	if len(allowedDirs) == 0 {
 		fmt.Fprintf(os.Stderr, "Usage: %s [-transport stdio|sse|http] [-port PORT] <allowed-directory> [additional-directories...]\n", os.Args[0])
 		os.Exit(1)
 	}
 
+	hooks := &server.Hooks{}
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		tools.DropJournal(session.SessionID())
+	})
+
 	mcpServer := server.NewMCPServer(
 		"filesystem",
 		"1.0.0",
 		server.WithToolCapabilities(true),
 		server.WithLogging(),
+		server.WithHooks(hooks),
 	)
 
 	mcpServer.AddTool(
-		mcp.NewTool("list_directory",
-			mcp.WithDescription("Get a detailed listing of all files and directories in a specified path. "+
+		newTool("list_directory",
+			"Get a detailed listing of all files and directories in a specified path. "+
 				"Results clearly distinguish between files and directories with [FILE] and [DIR] "+
 				"prefixes. This tool is essential for understanding directory structure and "+
-				"finding specific files within a directory. Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("Directory path"), mcp.Required()),
+				"finding specific files within a directory. Only works within allowed directories.",
+			tools.ListDirectoryParams{},
 		),
 		makeHandleListDirectory(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("read_file",
-			mcp.WithDescription("Read the complete contents of a file from the file system. "+
+		newTool("read_file",
+			"Read the complete contents of a file from the file system. "+
 				"Handles various text encodings and provides detailed error messages "+
 				"if the file cannot be read. Use this tool when you need to examine "+
 				"the contents of a single file. Use the 'head' parameter to read only "+
 				"the first N lines of a file, or the 'tail' parameter to read only "+
-				"the last N lines of a file. Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("File path"), mcp.Required()),
+				"the last N lines of a file, or 'startLine'/'endLine' for a range. Ranged reads stream the file. "+
+				"The result includes the SHA-256 hash of the whole file, which line-addressed edit_file "+
+				"operations take as expectedHash. Only works within allowed directories.",
+			tools.ReadFileParams{},
 		),
 		makeHandleReadFile(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("write_file",
-			mcp.WithDescription("Create a new file or completely overwrite an existing file with new content. "+
+		newTool("write_file",
+			"Create a new file or completely overwrite an existing file with new content. "+
 				"Use with caution as it will overwrite existing files without warning. "+
-				"Handles text content with proper encoding. Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("File path"), mcp.Required()),
-			mcp.WithString("content", mcp.Description("File content"), mcp.Required()),
+				"When overwriting a text file, the content is converted to its existing line endings (LF or CRLF) "+
+				"and byte order mark unless preserveLineEndings is false; binary content is written as given. "+
+				"With dryRun, nothing is written and a unified diff against the current content is returned. "+
+				"Only works within allowed directories.",
+			tools.WriteFileParams{},
 		),
 		makeHandleWriteFile(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("create_directory",
-			mcp.WithDescription("Create a new directory or ensure a directory exists. Can create multiple "+
+		newTool("create_directory",
+			"Create a new directory or ensure a directory exists. Can create multiple "+
 				"nested directories in one operation. If the directory already exists, "+
 				"this operation will succeed silently. Perfect for setting up directory "+
-				"structures for projects or ensuring required paths exist. Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("Directory path"), mcp.Required()),
+				"structures for projects or ensuring required paths exist. Only works within allowed directories.",
+			tools.CreateDirectoryParams{},
 		),
 		makeHandleCreateDirectory(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("get_file_info",
-			mcp.WithDescription("Retrieve detailed metadata about a file or directory. Returns comprehensive "+
+		newTool("get_file_info",
+			"Retrieve detailed metadata about a file or directory. Returns comprehensive "+
 				"information including size, creation time, last modified time, permissions, "+
-				"and type. This tool is perfect for understanding file characteristics "+
-				"without reading the actual content. Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("Path"), mcp.Required()),
+				"and type. Symbolic links are not followed: 'isSymlink' and 'linkTarget' describe the link itself. "+
+				"This tool is perfect for understanding file characteristics "+
+				"without reading the actual content. Only works within allowed directories.",
+			tools.GetFileInfoParams{},
 		),
 		makeHandleGetFileInfo(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("move_file",
-			mcp.WithDescription("Move or rename files and directories. Can move files between directories "+
+		newTool("create_symlink",
+			"Create a symbolic link at 'path' pointing to 'target'. A relative target is stored as given and "+
+				"resolved relative to the link's directory. The target must resolve inside the allowed directories; "+
+				"links that lead outside them are refused when followed later. Only works within allowed directories.",
+			tools.CreateSymlinkParams{},
+		),
+		makeHandleCreateSymlink(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("create_hardlink",
+			"Create a hard link at 'path' to the existing file 'target'. Directories cannot be hard linked. "+
+				"Both paths must be within allowed directories.",
+			tools.CreateHardlinkParams{},
+		),
+		makeHandleCreateHardlink(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("read_link",
+			"Read the target of a symbolic link. Returns the stored target, the fully resolved path, "+
+				"whether it lies inside the allowed directories and whether it exists. Only works within allowed directories.",
+			tools.ReadLinkParams{},
+		),
+		makeHandleReadLink(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("set_permissions",
+			"Change file or directory permissions, like chmod. Accepts an octal mode (\"755\", \"0644\") "+
+				"or a symbolic one (\"u+x\", \"go-w\", \"a=rX\"). Set 'recursive' to apply it to everything below a directory; "+
+				"symbolic links are skipped. Only works within allowed directories.",
+			tools.SetPermissionsParams{},
+		),
+		makeHandleSetPermissions(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("set_owner",
+			"Change the owning user and/or group of a path, like chown. Names and numeric ids are accepted. "+
+				"Only available when the server runs with -allow-chown. Only works within allowed directories.",
+			tools.SetOwnerParams{},
+		),
+		makeHandleSetOwner(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("set_times",
+			"Set the access and modification times of an existing path, like touch. "+
+				"Times are RFC 3339 timestamps or \"now\"; an omitted time is left unchanged, and with neither given both are set to now. "+
+				"Only works within allowed directories.",
+			tools.SetTimesParams{},
+		),
+		makeHandleSetTimes(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("move_file",
+			"Move or rename files and directories. Can move files between directories "+
 				"and rename them in a single operation. If the destination exists, the "+
-				"operation will fail. Works across different directories and can be used "+
-				"for simple renaming within the same directory. Both source and destination must be within allowed directories."),
-			mcp.WithString("source", mcp.Description("Source path"), mcp.Required()),
-			mcp.WithString("destination", mcp.Description("Destination path"), mcp.Required()),
+				"operation will fail unless 'overwrite' is set. Works across different directories and filesystems "+
+				"(falling back to copy and delete) and can be used for simple renaming within the same directory. "+
+				"Pass 'items' instead of source/destination to move many entries in one call. "+
+				"Both source and destination must be within allowed directories.",
+			tools.MoveFileParams{},
 		),
 		makeHandleMoveFile(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("delete_file",
-			mcp.WithDescription("Delete file or directory"),
-			mcp.WithString("path", mcp.Description("Path to delete"), mcp.Required()),
+		newTool("copy_file",
+			"Copy a single file, preserving its permissions and modification time. "+
+				"Uses a reflink clone or copy_file_range on Linux when the filesystem supports it. "+
+				"The 'overwrite' policy decides what happens when the destination exists: fail (default), skip or overwrite. "+
+				"Set 'verify' to compare SHA-256 checksums after copying. Both source and destination must be within allowed directories.",
+			tools.CopyFileParams{},
+		),
+		makeHandleCopyFile(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("copy_directory",
+			"Recursively copy a directory, preserving permissions and modification times of every entry. "+
+				"Symbolic links are recreated, not followed. The 'overwrite' policy applies to each existing file: "+
+				"fail (default, refuses if the destination exists), skip or overwrite. "+
+				"Set 'verify' to compare SHA-256 checksums of every copied file. Both source and destination must be within allowed directories.",
+			tools.CopyDirectoryParams{},
+		),
+		makeHandleCopyDirectory(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("delete_file",
+			"Delete file or directory by moving it into the trash of its allowed root. "+
+				"Trashed entries can be listed with list_trash and brought back with restore_from_trash. "+
+				"Set 'permanent' to remove the entry immediately when the server policy allows it. "+
+				"Non-empty directories require 'recursive'; 'dryRun' returns the files and total bytes that would be removed.",
+			tools.DeleteFileParams{},
 		),
 		makeHandleDeleteFile(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("search_files",
-			mcp.WithDescription("Recursively search for files and directories matching a pattern. "+
-				"Searches through all subdirectories from the starting path. The search "+
-				"is case-insensitive and matches partial names. Returns full paths to all "+
-				"matching items. Great for finding files when you don't know their exact location. "+
-				"Only searches within allowed directories."),
-			mcp.WithString("path", mcp.Description("Start directory"), mcp.Required()),
-			mcp.WithString("pattern", mcp.Description("Glob pattern"), mcp.Required()),
-			mcp.WithArray("excludePatterns", mcp.Items(map[string]any{"type": "string"})),
+		newTool("list_trash",
+			"List entries in the trash with their id, original path, deletion time and size. "+
+				"Pass 'path' to list only the trash of the allowed root containing it.",
+			tools.ListTrashParams{},
+		),
+		makeHandleListTrash(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("restore_from_trash",
+			"Restore a trashed entry to its original path, or to 'destination' if given. "+
+				"Fails if the target already exists. Only works within allowed directories.",
+			tools.RestoreFromTrashParams{},
+		),
+		makeHandleRestoreFromTrash(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("empty_trash",
+			"Permanently remove trashed entries. Removes a single entry when 'id' is given, "+
+				"entries deleted longer ago than 'olderThan' (e.g. \"24h\"), or everything otherwise.",
+			tools.EmptyTrashParams{},
+		),
+		makeHandleEmptyTrash(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("search_files",
+			"Recursively search for files and directories by name. matchMode is glob (default), substring, "+
+				"regex or fuzzy (the pattern's characters in order), and matching ignores case unless caseSensitive "+
+				"is set. Exact name matches are listed first, then names starting with or containing the pattern. "+
+				"Globs follow gitignore "+
+				"conventions: without a slash they match entry names at any depth (\"*_test.go\"), with a slash "+
+				"they match the path from the starting directory (\"cmd/*/main.go\"), ** matches any number of "+
+				"directories (\"src/**/*.go\") and braces give alternatives (\"*.{go,mod}\"). excludePatterns use "+
+				"the same syntax and excluded directories are not entered. Other modes match the name, or the path "+
+				"when the pattern contains a slash. Returns paths relative to the starting directory with their type "+
+				"(file, dir or symlink). Paths ignored by .gitignore, .git/info/exclude or .mcpignore files, and .git "+
+				"directories, are skipped unless respectGitignore is false. Only searches within allowed directories.",
+			tools.SearchFilesParams{},
 		),
 		makeHandleSearchFiles(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("read_multiple_files",
-			mcp.WithDescription("Read the contents of multiple files simultaneously. This is more "+
+		newTool("grep_files",
+			"Search the content of text files below a directory for a literal string or Go regular expression, "+
+				"optionally ignoring case or matching whole words only. include and exclude are globs with the "+
+				"same syntax as search_files (** and braces supported); excluded directories are skipped. Paths "+
+				"ignored by .gitignore, .git/info/exclude or .mcpignore files, and .git directories, are skipped "+
+				"unless respectGitignore is false. Binary files and files over 10 MiB are skipped. Each match is returned with its path, line, column and line "+
+				"text, plus beforeContext/afterContext lines. maxMatchesPerFile and maxMatches limit the results. "+
+				"Only searches within allowed directories.",
+			tools.GrepFilesParams{},
+		),
+		makeHandleGrepFiles(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("find_files",
+			"Find files, directories and symlinks below a directory by metadata, like find(1). Filters: name "+
+				"globs (search_files syntax), type, minSize/maxSize (e.g. 10k, 1.5M), newerThan/olderThan (an age "+
+				"such as 2h or 7d, or an RFC 3339 time), perm (644 exact, -111 all bits, /022 any bit) and empty. "+
+				"Top-level filters must all match; anyOf lists alternative filter sets of which at least one must "+
+				"match. minDepth/maxDepth limit the depth, exclude prunes directories, and gitignored paths are "+
+				"skipped unless respectGitignore is false. Returns entries with path, type, size, mtime and mode. "+
+				"Only searches within allowed directories.",
+			tools.FindFilesParams{},
+		),
+		makeHandleFindFiles(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("read_multiple_files",
+			"Read the contents of multiple files simultaneously. This is more "+
 				"efficient than reading files one by one when you need to analyze "+
 				"or compare multiple files. Each file's content is returned with its "+
 				"path as a reference. Failed reads for individual files won't stop "+
-				"the entire operation. Only works within allowed directories."),
-			mcp.WithArray("paths", mcp.Items(map[string]any{"type": "string"})),
+				"the entire operation. Only works within allowed directories.",
+			tools.ReadMultipleFilesParams{},
 		),
 		makeHandleReadMultipleFiles(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("edit_file",
-			mcp.WithDescription("Make line-based edits to a text file. Each edit replaces exact line sequences "+
-				"with new content. Returns a git-style diff showing the changes made. "+
-				"Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("File to edit"), mcp.Required()),
-			mcp.WithArray("edits", mcp.Items(map[string]any{"type": "string"})),
-			mcp.WithBoolean("dryRun", mcp.Description("Preview changes without applying")),
+		newTool("edit_file",
+			"Make edits to a text file. Each edit replaces an exact match of oldText, which may span "+
+				"several lines, with newText. Edits apply in order. If oldText matches more than once the call fails "+
+				"unless replaceAll or a 1-based occurrence is given. With match set to whitespace, an edit that has no exact "+
+				"match may match whole lines that differ only in whitespace; newText is then reindented to the file's "+
+				"indentation and the confidence of the match is reported. With regex, oldText is a Go regular expression "+
+				"and newText may use $1-style group references; every replacement is reported with its line. "+
+				"Edits with op address lines instead: insert after "+
+				"line N, delete or replace lines N-M, or insertBefore/insertAfter the line matching a pattern. Their "+
+				"line numbers refer to the file as read and they require the expectedHash returned by read_file. "+
+				"Returns a unified diff of the change. "+
+				"Only works within allowed directories.",
+			tools.EditFileParams{},
 		),
 		makeHandleEditFile(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("list_allowed_directories",
-			mcp.WithDescription("Returns the list of directories that this server is allowed to access. "+
-				"Use this to understand which directories are available before trying to access files."),
+		newTool("apply_patch",
+			"Apply a unified diff that may cover several files, including git-style file creation, "+
+				"deletion and rename headers. Hunks are matched at their stated lines, then at nearby offsets, then "+
+				"with up to fuzz context lines ignored at each end. Returns the result of every hunk. By default "+
+				"nothing is written unless every hunk applies. Deleted files go to the trash; renamed files keep "+
+				"their permissions. Sections for the same file apply in order, and a section whose names differ "+
+				"without git rename headers patches the new name if it exists, else the old one, as patch(1) does. "+
+				"Only works within allowed directories.",
+			tools.ApplyPatchParams{},
+		),
+		makeHandleApplyPatch(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("replace_in_files",
+			"Replace a literal or regex pattern in every text file below a directory. include and "+
+				"exclude are globs with the same syntax as search_files (** and braces supported); "+
+				"excluded directories are skipped. Paths ignored by .gitignore, .git/info/exclude or .mcpignore "+
+				"files, and .git directories, are skipped unless respectGitignore is false. Binary files and "+
+				"files over 10 MiB are ignored. With dryRun, "+
+				"returns a unified diff and hash per file without writing. Otherwise all files are written together "+
+				"after checking each still has the content it was planned from, and the hashes in expectedHashes "+
+				"when given. Only works within allowed directories.",
+			tools.ReplaceInFilesParams{},
+		),
+		makeHandleReplaceInFiles(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("json_get",
+			"Read the value at a JSON Pointer (RFC 6901) in a JSON file, e.g. \"/scripts/build\" or \"/items/0\". "+
+				"An empty pointer returns the whole document. Only works within allowed directories.",
+			tools.JSONGetParams{},
+		),
+		makeHandleJSONGet(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("json_set",
+			"Set the value at a JSON Pointer in a JSON file, replacing it or adding a new member. \"/-\" at the end of "+
+				"the pointer appends to an array, and createParents creates missing objects on the way. Key order, "+
+				"indentation, line endings and the final newline are kept, and the result is checked to be valid JSON. "+
+				"Returns a unified diff. Only works within allowed directories.",
+			tools.JSONSetParams{},
+		),
+		makeHandleJSONSet(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("json_delete",
+			"Remove the object member or array element at a JSON Pointer in a JSON file, keeping the rest of the "+
+				"document's key order and indentation. Returns a unified diff. Only works within allowed directories.",
+			tools.JSONDeleteParams{},
+		),
+		makeHandleJSONDelete(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("json_patch",
+			"Apply a JSON Patch (RFC 6902) to a JSON file: add, remove, replace, move, copy and test operations, "+
+				"applied in order. If any operation fails, including a test, the file is left unchanged. Key order and "+
+				"indentation are kept and the result is checked to be valid JSON. Returns a unified diff. "+
+				"Only works within allowed directories.",
+			tools.JSONPatchParams{},
+		),
+		makeHandleJSONPatch(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("format_file",
+			"Canonicalize a Go, JSON or XML file: go/format for .go, json.Indent with the given indent for .json, "+
+				"and re-indentation with one element per line for .xml. Line endings and byte order mark are kept. "+
+				"With dryRun, returns the formatted content and a unified diff without writing. "+
+				"Only works within allowed directories.",
+			tools.FormatFileParams{},
+		),
+		makeHandleFormatFile(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("normalize_line_endings",
+			"Convert a text file's line endings to LF or CRLF, and optionally add or remove its UTF-8 BOM "+
+				"and final newline. Reports the detected style before and after. Only works within allowed directories.",
+			tools.NormalizeLineEndingsParams{},
+		),
+		makeHandleNormalizeLineEndings(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("batch",
+			"Apply an ordered list of write, edit, move, delete and mkdir operations as one unit. "+
+				"Every operation is checked against the allowed directories before anything is changed. "+
+				"If an operation fails, the ones already applied are rolled back in reverse order. "+
+				"Returns a per-operation report with status applied, failed, skipped, rolledBack or rollbackFailed.",
+			tools.BatchParams{},
+		),
+		makeHandleBatch(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("undo",
+			"Revert the most recent mutations made in this session: every tool that changes files, their "+
+				"content, permissions, owners, times or links is journaled, except restore_from_trash and "+
+				"empty_trash. Undoing a delete takes the item back "+
+				"out of the trash. Refuses with a conflict report if an affected file was changed outside the "+
+				"server since the operation.",
+			tools.UndoParams{},
+		),
+		makeHandleUndo(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("redo",
+			"Reapply operations reverted with undo in this session. "+
+				"Refuses with a conflict report if an affected file was changed since the undo.",
+			tools.RedoParams{},
+		),
+		makeHandleRedo(allowedDirs),
+	)
+	mcpServer.AddTool(
+		newTool("list_allowed_directories",
+			"Returns the list of directories that this server is allowed to access, and under readOnly "+
+				"those that tools may read but not change. "+
+				"Use this to understand which directories are available before trying to access files.",
+			struct{}{},
 		),
 		makeHandleListAllowedDirectories(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("list_directory_with_sizes",
-			mcp.WithDescription("Get a detailed listing of all files and directories in a specified path, including sizes. Results clearly distinguish between files and directories with [FILE] and [DIR] prefixes. This tool is useful for understanding directory structure and finding specific files within a directory. Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("Directory path"), mcp.Required()),
-			mcp.WithString("sortBy", mcp.Description("Sort entries by name or size (name|size)")),
+		newTool("list_directory_with_sizes",
+			"Get a detailed listing of all files and directories in a specified path, including sizes. Results clearly distinguish between files and directories with [FILE] and [DIR] prefixes. This tool is useful for understanding directory structure and finding specific files within a directory. Set respectGitignore to leave out entries ignored by .gitignore, .git/info/exclude or .mcpignore files. Only works within allowed directories.",
+			tools.ListDirectoryWithSizesParams{},
 		),
 		makeHandleListDirectoryWithSizes(allowedDirs),
 	)
 	mcpServer.AddTool(
-		mcp.NewTool("directory_tree",
-			mcp.WithDescription("Get a recursive tree view of files and directories as a JSON structure. Each entry includes 'name', 'type' (file/directory), and 'children' for directories. Files have no children array, while directories always have a children array (which may be empty). The output is formatted with 2-space indentation for readability. Only works within allowed directories."),
-			mcp.WithString("path", mcp.Description("Directory path"), mcp.Required()),
+		newTool("directory_tree",
+			"Get a recursive tree view of files and directories as a JSON structure. Each entry includes 'name', 'type' (file/directory/symlink), and 'children' for directories. Symbolic links are not followed. Paths ignored by .gitignore, .git/info/exclude or .mcpignore files at any level, and .git directories, are left out unless respectGitignore is false. Files have no children array, while directories always have a children array (which may be empty). The output is formatted with 2-space indentation for readability. Only works within allowed directories.",
+			tools.DirectoryTreeParams{},
 		),
 		makeHandleDirectoryTree(allowedDirs),
 	)
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ad/mcp-filesystem/tools"
 )
@@ -232,3 +236,1721 @@ func TestDirectoryTree(t *testing.T) {
 		t.Error("DirectoryTree: subdir not found in tree")
 	}
 }
+
+func TestTrash(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.txt", []byte("abc"), 0644)
+	res, err := tools.DeleteFile(tools.DeleteFileParams{Path: "a.txt"}, []string{dir})
+	if err != nil {
+		t.Fatalf("DeleteFile error: %v", err)
+	}
+	id := res["trashId"].(string)
+
+	res, err = tools.ListTrash(tools.ListTrashParams{}, []string{dir})
+	if err != nil {
+		t.Fatalf("ListTrash error: %v", err)
+	}
+	entries := res["entries"].([]tools.TrashEntry)
+	if len(entries) != 1 || entries[0].ID != id || entries[0].OriginalPath != dir+"/a.txt" {
+		t.Fatalf("ListTrash: unexpected entries %+v", entries)
+	}
+
+	_, err = tools.RestoreFromTrash(tools.RestoreFromTrashParams{ID: id}, []string{dir})
+	if err != nil {
+		t.Fatalf("RestoreFromTrash error: %v", err)
+	}
+	if data, err := os.ReadFile(dir + "/a.txt"); err != nil || string(data) != "abc" {
+		t.Error("RestoreFromTrash: file not restored")
+	}
+
+	// Permanent delete is refused unless the policy allows it
+	_, err = tools.DeleteFile(tools.DeleteFileParams{Path: "a.txt", Permanent: true}, []string{dir})
+	if err == nil {
+		t.Error("Expected error for permanent delete with default policy")
+	}
+
+	// Deleting the trash itself is refused
+	tools.DeleteFile(tools.DeleteFileParams{Path: "a.txt"}, []string{dir})
+	_, err = tools.DeleteFile(tools.DeleteFileParams{Path: ".mcp-trash"}, []string{dir})
+	if err == nil {
+		t.Error("Expected error when deleting the trash directory")
+	}
+
+	res, err = tools.EmptyTrash(tools.EmptyTrashParams{}, []string{dir})
+	if err != nil {
+		t.Fatalf("EmptyTrash error: %v", err)
+	}
+	if len(res["removed"].([]string)) != 1 {
+		t.Errorf("EmptyTrash: expected one removed entry, got %v", res["removed"])
+	}
+}
+
+func TestTrashPolicy(t *testing.T) {
+	defer tools.SetPolicy(tools.DefaultPolicy())
+	dir := t.TempDir()
+
+	policy := tools.DefaultPolicy()
+	policy.AllowPermanentDelete = true
+	policy.TrashMaxSize = 5
+	tools.SetPolicy(policy)
+
+	os.WriteFile(dir+"/p.txt", []byte("x"), 0644)
+	_, err := tools.DeleteFile(tools.DeleteFileParams{Path: "p.txt", Permanent: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("DeleteFile permanent error: %v", err)
+	}
+	if _, err := os.Stat(dir + "/.mcp-trash"); !os.IsNotExist(err) {
+		t.Error("Permanent delete should not use the trash")
+	}
+
+	// Oldest entries are purged once the trash exceeds its size limit
+	os.WriteFile(dir+"/old.txt", []byte("1234"), 0644)
+	os.WriteFile(dir+"/new.txt", []byte("5678"), 0644)
+	tools.DeleteFile(tools.DeleteFileParams{Path: "old.txt"}, []string{dir})
+	tools.DeleteFile(tools.DeleteFileParams{Path: "new.txt"}, []string{dir})
+	res, err := tools.ListTrash(tools.ListTrashParams{}, []string{dir})
+	if err != nil {
+		t.Fatalf("ListTrash error: %v", err)
+	}
+	entries := res["entries"].([]tools.TrashEntry)
+	if len(entries) != 1 || entries[0].OriginalPath != dir+"/new.txt" {
+		t.Errorf("Trash size purge: unexpected entries %+v", entries)
+	}
+
+	// An item larger than the whole trash evicts the others but is kept
+	os.WriteFile(dir+"/big.txt", []byte("0123456789"), 0644)
+	if _, err := tools.DeleteFile(tools.DeleteFileParams{Path: "big.txt"}, []string{dir}); err != nil {
+		t.Fatalf("DeleteFile of an item over the trash limit: %v", err)
+	}
+	res, _ = tools.ListTrash(tools.ListTrashParams{}, []string{dir})
+	if entries := res["entries"].([]tools.TrashEntry); len(entries) != 1 || entries[0].OriginalPath != dir+"/big.txt" {
+		t.Errorf("Trash after deleting a large item: %+v", entries)
+	}
+}
+
+func TestTrashIsProtected(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.txt", []byte("x"), 0644)
+	res, err := tools.DeleteFile(tools.DeleteFileParams{Path: "a.txt"}, []string{dir})
+	if err != nil {
+		t.Fatalf("DeleteFile error: %v", err)
+	}
+	info := ".mcp-trash/" + res["trashId"].(string) + "/info.json"
+	if _, err := tools.WriteFile(tools.WriteFileParams{Path: info, Content: "{}"}, []string{dir}); err == nil {
+		t.Error("Expected write_file into the trash to be refused")
+	}
+	if _, err := tools.ReadFile(tools.ReadFileParams{Path: info}, []string{dir}); err == nil {
+		t.Error("Expected read_file inside the trash to be refused")
+	}
+	os.Symlink(dir+"/.mcp-trash", dir+"/peek")
+	if _, err := tools.ReadFile(tools.ReadFileParams{Path: "peek/" + res["trashId"].(string) + "/info.json"}, []string{dir}); err == nil {
+		t.Error("Expected a link into the trash to be refused")
+	}
+	os.Remove(dir + "/peek")
+
+	res, _ = tools.ListDirectory(tools.ListDirectoryParams{Path: "."}, []string{dir})
+	if entries := res["entries"].([]map[string]string); len(entries) != 0 {
+		t.Errorf("ListDirectory shows the trash: %v", entries)
+	}
+	res, _ = tools.DirectoryTree(tools.DirectoryTreeParams{Path: "."}, []string{dir})
+	if children := res["tree"].(tools.TreeEntry).Children; len(children) != 0 {
+		t.Errorf("DirectoryTree shows the trash: %v", children)
+	}
+	res, _ = tools.ListTrash(tools.ListTrashParams{}, []string{dir})
+	entries := res["entries"].([]tools.TrashEntry)
+	if len(entries) != 1 {
+		t.Fatalf("Trash entry lost: %+v", entries)
+	}
+
+	// The recorded original path is checked like a destination.
+	outside := t.TempDir()
+	os.Symlink(outside, dir+"/out")
+	entry := entries[0]
+	entry.OriginalPath = dir + "/out/a.txt"
+	b, _ := json.Marshal(entry)
+	os.WriteFile(filepath.Join(dir, info), b, 0600)
+	if _, err := tools.RestoreFromTrash(tools.RestoreFromTrashParams{ID: entry.ID}, []string{dir}); err == nil {
+		t.Error("Expected a restore through a link out of the root to be refused")
+	}
+	if _, err := os.Stat(outside + "/a.txt"); err == nil {
+		t.Error("Restore wrote outside the allowed directory")
+	}
+}
+
+func TestDeleteFileRecursive(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/tree/sub", 0755)
+	os.WriteFile(dir+"/tree/a.txt", []byte("abc"), 0644)
+	os.WriteFile(dir+"/tree/sub/b.txt", []byte("de"), 0644)
+	os.Mkdir(dir+"/empty", 0755)
+
+	_, err := tools.DeleteFile(tools.DeleteFileParams{Path: "tree"}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "tree/sub/b.txt") {
+		t.Errorf("Expected refusal listing tree contents, got %v", err)
+	}
+
+	res, err := tools.DeleteFile(tools.DeleteFileParams{Path: "tree", Recursive: true, DryRun: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("DeleteFile dry run error: %v", err)
+	}
+	if len(res["files"].([]string)) != 2 || res["totalBytes"].(int64) != 5 {
+		t.Errorf("DeleteFile dry run: unexpected manifest %v", res)
+	}
+	if _, err := os.Stat(dir + "/tree"); err != nil {
+		t.Error("DeleteFile dry run removed the directory")
+	}
+
+	// Empty directories don't need the recursive flag
+	if _, err := tools.DeleteFile(tools.DeleteFileParams{Path: "empty"}, []string{dir}); err != nil {
+		t.Errorf("DeleteFile empty directory error: %v", err)
+	}
+
+	defer tools.SetPolicy(tools.DefaultPolicy())
+	policy := tools.DefaultPolicy()
+	policy.MaxDeleteEntries = 3
+	tools.SetPolicy(policy)
+	_, err = tools.DeleteFile(tools.DeleteFileParams{Path: "tree", Recursive: true}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "limit of 3") {
+		t.Errorf("Expected entry limit refusal, got %v", err)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/run.sh", []byte("#!/bin/sh\n"), 0755)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(dir+"/run.sh", mtime, mtime)
+
+	_, err := tools.CopyFile(tools.CopyFileParams{Source: "run.sh", Destination: "copy.sh", Verify: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("CopyFile error: %v", err)
+	}
+	info, err := os.Stat(dir + "/copy.sh")
+	if err != nil {
+		t.Fatalf("CopyFile: copy not created: %v", err)
+	}
+	if info.Mode().Perm() != 0755 || !info.ModTime().Equal(mtime) {
+		t.Errorf("CopyFile: mode/mtime not preserved: %v %v", info.Mode(), info.ModTime())
+	}
+
+	// Default policy refuses to overwrite, skip leaves the file untouched
+	os.WriteFile(dir+"/other.sh", []byte("other"), 0644)
+	if _, err := tools.CopyFile(tools.CopyFileParams{Source: "run.sh", Destination: "other.sh"}, []string{dir}); err == nil {
+		t.Error("Expected error when destination exists")
+	}
+	res, err := tools.CopyFile(tools.CopyFileParams{Source: "run.sh", Destination: "other.sh", Overwrite: "skip"}, []string{dir})
+	if err != nil || res["skipped"].(int) != 1 {
+		t.Errorf("CopyFile skip: unexpected result %v, %v", res, err)
+	}
+	if _, err := tools.CopyFile(tools.CopyFileParams{Source: "run.sh", Destination: "other.sh", Overwrite: "overwrite"}, []string{dir}); err != nil {
+		t.Fatalf("CopyFile overwrite error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/other.sh"); string(data) != "#!/bin/sh\n" {
+		t.Error("CopyFile overwrite: content not replaced")
+	}
+
+	// Overwrites replace the destination by rename instead of truncating it,
+	// so other links to the old file keep their content
+	os.Link(dir+"/other.sh", dir+"/other-link.sh")
+	os.WriteFile(dir+"/new.sh", []byte("new"), 0644)
+	if _, err := tools.CopyFile(tools.CopyFileParams{Source: "new.sh", Destination: "other.sh", Overwrite: "overwrite"}, []string{dir}); err != nil {
+		t.Fatalf("CopyFile overwrite error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/other-link.sh"); string(data) != "#!/bin/sh\n" {
+		t.Errorf("CopyFile overwrite changed the old file in place: %q", data)
+	}
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Errorf("CopyFile left a temporary file: %s", e.Name())
+		}
+	}
+}
+
+func TestCopyDirectory(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/src/sub", 0750)
+	os.WriteFile(dir+"/src/a.txt", []byte("A"), 0600)
+	os.WriteFile(dir+"/src/sub/b.txt", []byte("B"), 0644)
+
+	res, err := tools.CopyDirectory(tools.CopyDirectoryParams{Source: "src", Destination: "dst"}, []string{dir})
+	if err != nil {
+		t.Fatalf("CopyDirectory error: %v", err)
+	}
+	if res["copied"].(int) != 2 {
+		t.Errorf("CopyDirectory: expected 2 copied files, got %v", res["copied"])
+	}
+	if data, _ := os.ReadFile(dir + "/dst/sub/b.txt"); string(data) != "B" {
+		t.Error("CopyDirectory: nested file not copied")
+	}
+	if info, err := os.Stat(dir + "/dst/sub"); err != nil || info.Mode().Perm() != 0750 {
+		t.Error("CopyDirectory: directory mode not preserved")
+	}
+
+	if _, err := tools.CopyDirectory(tools.CopyDirectoryParams{Source: "src", Destination: "src/inner"}, []string{dir}); err == nil {
+		t.Error("Expected error when copying a directory into itself")
+	}
+	if _, err := tools.CopyDirectory(tools.CopyDirectoryParams{Source: "src", Destination: "/etc/copy"}, []string{dir}); err == nil {
+		t.Error("Expected error for destination outside allowed directories")
+	}
+}
+
+func TestMoveFileOverwriteAndBatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.txt", []byte("A"), 0644)
+	os.WriteFile(dir+"/b.txt", []byte("B"), 0644)
+
+	_, err := tools.MoveFile(tools.MoveFileParams{Source: "a.txt", Destination: "b.txt"}, []string{dir})
+	if err == nil {
+		t.Error("Expected error when destination exists")
+	}
+	_, err = tools.MoveFile(tools.MoveFileParams{Source: "a.txt", Destination: "b.txt", Overwrite: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("MoveFile overwrite error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/b.txt"); string(data) != "A" {
+		t.Error("MoveFile overwrite: destination not replaced")
+	}
+
+	os.WriteFile(dir+"/c.txt", []byte("C"), 0644)
+	os.Mkdir(dir+"/out", 0755)
+	res, err := tools.MoveFile(tools.MoveFileParams{Items: []tools.MoveItem{
+		{Source: "b.txt", Destination: "out/b.txt"},
+		{Source: "missing.txt", Destination: "out/missing.txt"},
+		{Source: "c.txt", Destination: "out/c.txt"},
+	}}, []string{dir})
+	if err != nil {
+		t.Fatalf("MoveFile batch error: %v", err)
+	}
+	results := res["results"].([]map[string]interface{})
+	if res["ok"].(bool) || !results[0]["ok"].(bool) || results[1]["ok"].(bool) || !results[2]["ok"].(bool) {
+		t.Errorf("MoveFile batch: unexpected results %v", results)
+	}
+
+	// Confinement is checked for every item before anything moves
+	os.WriteFile(dir+"/d.txt", []byte("D"), 0644)
+	_, err = tools.MoveFile(tools.MoveFileParams{Items: []tools.MoveItem{
+		{Source: "d.txt", Destination: "out/d.txt"},
+		{Source: "out/c.txt", Destination: "/etc/c.txt"},
+	}}, []string{dir})
+	if err == nil {
+		t.Error("Expected error for batch item outside allowed directories")
+	}
+	if _, err := os.Stat(dir + "/d.txt"); err != nil {
+		t.Error("MoveFile batch: item moved despite failed validation")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.txt", []byte("hello world"), 0644)
+	os.WriteFile(dir+"/old.txt", []byte("old"), 0644)
+
+	res, err := tools.Batch(tools.BatchParams{Operations: []tools.BatchOperation{
+		{Op: "mkdir", Path: "pkg"},
+		{Op: "write", Path: "pkg/new.txt", Content: "new"},
+		{Op: "edit", Path: "a.txt", Edits: []tools.EditOperation{{OldText: "world", NewText: "there"}}},
+		{Op: "move", Source: "old.txt", Destination: "pkg/old.txt"},
+	}}, []string{dir})
+	if err != nil {
+		t.Fatalf("Batch error: %v", err)
+	}
+	if !res["ok"].(bool) {
+		t.Fatalf("Batch: expected success, got %v", res)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); string(data) != "hello there" {
+		t.Error("Batch: edit not applied")
+	}
+
+	// A failing operation rolls back the ones before it
+	res, err = tools.Batch(tools.BatchParams{Operations: []tools.BatchOperation{
+		{Op: "write", Path: "a.txt", Content: "overwritten"},
+		{Op: "delete", Path: "pkg", Recursive: true},
+		{Op: "move", Source: "missing.txt", Destination: "x.txt"},
+	}}, []string{dir})
+	if err != nil {
+		t.Fatalf("Batch error: %v", err)
+	}
+	if res["ok"].(bool) || res["failedAt"].(int) != 2 || !res["rolledBack"].(bool) {
+		t.Errorf("Batch: expected rollback report, got %v", res)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); string(data) != "hello there" {
+		t.Error("Batch: write not rolled back")
+	}
+	if data, _ := os.ReadFile(dir + "/pkg/new.txt"); string(data) != "new" {
+		t.Error("Batch: delete not rolled back")
+	}
+
+	// Nothing is applied when an operation escapes the allowed directories
+	_, err = tools.Batch(tools.BatchParams{Operations: []tools.BatchOperation{
+		{Op: "write", Path: "b.txt", Content: "b"},
+		{Op: "write", Path: "/etc/passwd", Content: "x"},
+	}}, []string{dir})
+	if err == nil {
+		t.Error("Expected validation error for path outside allowed directories")
+	}
+	if _, err := os.Stat(dir + "/b.txt"); !os.IsNotExist(err) {
+		t.Error("Batch: operation applied despite failed validation")
+	}
+}
+
+func TestUndoRedo(t *testing.T) {
+	dir := t.TempDir()
+	session := t.Name()
+	defer tools.DropJournal(session)
+	write := func(path, content string) {
+		params := tools.WriteFileParams{Path: path, Content: content}
+		_, err := tools.Journaled(session, "write_file", []string{path}, []string{dir}, func() (tools.ToolResult, error) {
+			return tools.WriteFile(params, []string{dir})
+		})
+		if err != nil {
+			t.Fatalf("WriteFile error: %v", err)
+		}
+	}
+	write("a.txt", "one")
+	write("a.txt", "two")
+	os.MkdirAll(dir+"/tree/sub", 0755)
+	os.WriteFile(dir+"/tree/sub/x.txt", []byte("x"), 0644)
+	_, err := tools.Journaled(session, "delete_file", []string{"tree"}, []string{dir}, func() (tools.ToolResult, error) {
+		return tools.DeleteFile(tools.DeleteFileParams{Path: "tree", Recursive: true}, []string{dir})
+	})
+	if err != nil {
+		t.Fatalf("DeleteFile error: %v", err)
+	}
+
+	if _, err := tools.Undo(tools.UndoParams{}, session, []string{dir}); err != nil {
+		t.Fatalf("Undo delete error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/tree/sub/x.txt"); string(data) != "x" {
+		t.Error("Undo: deleted tree not restored")
+	}
+	trashEntries := func() int {
+		res, _ := tools.ListTrash(tools.ListTrashParams{}, []string{dir})
+		return len(res["entries"].([]tools.TrashEntry))
+	}
+	if n := trashEntries(); n != 0 {
+		t.Errorf("Undo of a delete left %d trash entries", n)
+	}
+	// Redoing the delete trashes the tree again.
+	if _, err := tools.Redo(tools.RedoParams{}, session, []string{dir}); err != nil {
+		t.Fatalf("Redo delete error: %v", err)
+	}
+	if _, err := os.Stat(dir + "/tree"); !os.IsNotExist(err) || trashEntries() != 1 {
+		t.Errorf("Redo of a delete: tree %v, %d trash entries", err, trashEntries())
+	}
+	if _, err := tools.Undo(tools.UndoParams{}, session, []string{dir}); err != nil {
+		t.Fatalf("Undo delete again error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/tree/sub/x.txt"); string(data) != "x" || trashEntries() != 0 {
+		t.Errorf("Second undo of a delete: %q, %d trash entries", data, trashEntries())
+	}
+	if _, err := tools.Undo(tools.UndoParams{Steps: 1}, session, []string{dir}); err != nil {
+		t.Fatalf("Undo write error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); string(data) != "one" {
+		t.Errorf("Undo: expected 'one', got %q", data)
+	}
+	if _, err := tools.Redo(tools.RedoParams{}, session, []string{dir}); err != nil {
+		t.Fatalf("Redo error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); string(data) != "two" {
+		t.Errorf("Redo: expected 'two', got %q", data)
+	}
+
+	// External changes block undo with a conflict report
+	os.WriteFile(dir+"/a.txt", []byte("external"), 0644)
+	res, err := tools.Undo(tools.UndoParams{}, session, []string{dir})
+	if err != nil {
+		t.Fatalf("Undo error: %v", err)
+	}
+	if res["ok"].(bool) || len(res["conflicts"].([]map[string]interface{})) != 1 {
+		t.Errorf("Undo: expected conflict report, got %v", res)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); string(data) != "external" {
+		t.Error("Undo: conflicting file was modified")
+	}
+
+	// Sessions don't share journals
+	if _, err := tools.Undo(tools.UndoParams{}, session+"-other", []string{dir}); err == nil {
+		t.Error("Expected error when another session has nothing to undo")
+	}
+}
+
+func TestUndoMetadata(t *testing.T) {
+	dir := t.TempDir()
+	session := t.Name()
+	defer tools.DropJournal(session)
+	run := func(tool, path string, fn func() (tools.ToolResult, error)) {
+		if _, err := tools.Journaled(session, tool, []string{path}, []string{dir}, fn); err != nil {
+			t.Fatalf("%s error: %v", tool, err)
+		}
+	}
+	run("write_file", "a.txt", func() (tools.ToolResult, error) {
+		return tools.WriteFile(tools.WriteFileParams{Path: "a.txt", Content: "one"}, []string{dir})
+	})
+	before, _ := os.Stat(dir + "/a.txt")
+	run("set_times", "a.txt", func() (tools.ToolResult, error) {
+		return tools.SetTimes(tools.SetTimesParams{Path: "a.txt", Mtime: "2001-02-03T04:05:06Z"}, []string{dir})
+	})
+
+	// Undoing set_times restores the time instead of skipping to write_file
+	if _, err := tools.Undo(tools.UndoParams{}, session, []string{dir}); err != nil {
+		t.Fatalf("Undo error: %v", err)
+	}
+	info, err := os.Stat(dir + "/a.txt")
+	if err != nil {
+		t.Fatalf("Undo of set_times removed the file: %v", err)
+	}
+	if !info.ModTime().Equal(before.ModTime()) {
+		t.Errorf("Undo: mtime %v, want %v", info.ModTime(), before.ModTime())
+	}
+	if _, err := tools.Redo(tools.RedoParams{}, session, []string{dir}); err != nil {
+		t.Fatalf("Redo error: %v", err)
+	}
+	if info, _ := os.Stat(dir + "/a.txt"); info.ModTime().Year() != 2001 {
+		t.Errorf("Redo: mtime %v", info.ModTime())
+	}
+
+	// Restores rewrite files in place, keeping hard links intact
+	run("write_file", "a.txt", func() (tools.ToolResult, error) {
+		return tools.WriteFile(tools.WriteFileParams{Path: "a.txt", Content: "two"}, []string{dir})
+	})
+	os.Link(dir+"/a.txt", dir+"/b.txt")
+	if _, err := tools.Undo(tools.UndoParams{}, session, []string{dir}); err != nil {
+		t.Fatalf("Undo error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/b.txt"); string(data) != "one" {
+		t.Errorf("Undo broke the hard link: b.txt has %q", data)
+	}
+}
+
+func TestLineEndingPreservation(t *testing.T) {
+	dir := t.TempDir()
+	bom := "\xEF\xBB\xBF"
+	os.WriteFile(dir+"/win.txt", []byte(bom+"one\r\ntwo\r\nthree\r\n"), 0644)
+
+	_, err := tools.EditFile(tools.EditFileParams{Path: "win.txt", Edits: []tools.EditOperation{{OldText: "two", NewText: "TWO"}}}, []string{dir})
+	if err != nil {
+		t.Fatalf("EditFile error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/win.txt"); string(data) != bom+"one\r\nTWO\r\nthree\r\n" {
+		t.Errorf("EditFile: style not preserved: %q", data)
+	}
+
+	_, err = tools.WriteFile(tools.WriteFileParams{Path: "win.txt", Content: "a\nb\n"}, []string{dir})
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/win.txt"); string(data) != bom+"a\r\nb\r\n" {
+		t.Errorf("WriteFile: style not preserved: %q", data)
+	}
+
+	// Opting out, or binary content, writes the bytes as given.
+	os.WriteFile(dir+"/mixed.txt", []byte("a\r\nb\r\n"), 0644)
+	keep := false
+	tools.WriteFile(tools.WriteFileParams{Path: "mixed.txt", Content: "a\r\nb\nc\n", PreserveLineEndings: &keep}, []string{dir})
+	if data, _ := os.ReadFile(dir + "/mixed.txt"); string(data) != "a\r\nb\nc\n" {
+		t.Errorf("WriteFile with preserveLineEndings false: %q", data)
+	}
+	os.WriteFile(dir+"/blob.bin", []byte("x\r\ny\r\n"), 0644)
+	tools.WriteFile(tools.WriteFileParams{Path: "blob.bin", Content: "\x00\n\x01\n"}, []string{dir})
+	if data, _ := os.ReadFile(dir + "/blob.bin"); string(data) != "\x00\n\x01\n" {
+		t.Errorf("WriteFile changed binary content: %q", data)
+	}
+
+	res, err := tools.NormalizeLineEndings(tools.NormalizeLineEndingsParams{Path: "win.txt", LineEnding: "lf", BOM: "remove", FinalNewline: "remove"}, []string{dir})
+	if err != nil {
+		t.Fatalf("NormalizeLineEndings error: %v", err)
+	}
+	if !res["changed"].(bool) {
+		t.Error("NormalizeLineEndings: expected a change")
+	}
+	if data, _ := os.ReadFile(dir + "/win.txt"); string(data) != "a\nb" {
+		t.Errorf("NormalizeLineEndings: unexpected content %q", data)
+	}
+
+	// Only the final newline is removed, not the blank lines before it
+	os.WriteFile(dir+"/blank.txt", []byte("a\nb\n\n\n"), 0644)
+	if _, err := tools.NormalizeLineEndings(tools.NormalizeLineEndingsParams{Path: "blank.txt", FinalNewline: "remove"}, []string{dir}); err != nil {
+		t.Fatalf("NormalizeLineEndings error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/blank.txt"); string(data) != "a\nb\n\n" {
+		t.Errorf("NormalizeLineEndings: unexpected content %q", data)
+	}
+}
+
+func TestSetPermissions(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/run.sh", []byte("#!/bin/sh\n"), 0644)
+	_, err := tools.SetPermissions(tools.SetPermissionsParams{Path: "run.sh", Mode: "u+x,g+x"}, []string{dir})
+	if err != nil {
+		t.Fatalf("SetPermissions error: %v", err)
+	}
+	if info, _ := os.Stat(dir + "/run.sh"); info.Mode().Perm() != 0754 {
+		t.Errorf("SetPermissions symbolic: expected 0754, got %o", info.Mode().Perm())
+	}
+
+	os.MkdirAll(dir+"/tree/sub", 0755)
+	os.WriteFile(dir+"/tree/sub/a.txt", []byte("a"), 0644)
+	res, err := tools.SetPermissions(tools.SetPermissionsParams{Path: "tree", Mode: "go=", Recursive: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("SetPermissions recursive error: %v", err)
+	}
+	if res["entries"].(int) != 3 {
+		t.Errorf("SetPermissions: expected 3 entries, got %v", res["entries"])
+	}
+	if info, _ := os.Stat(dir + "/tree/sub/a.txt"); info.Mode().Perm() != 0600 {
+		t.Errorf("SetPermissions recursive: expected 0600, got %o", info.Mode().Perm())
+	}
+
+	if _, err := tools.SetPermissions(tools.SetPermissionsParams{Path: "run.sh", Mode: "0640"}, []string{dir}); err != nil {
+		t.Fatalf("SetPermissions octal error: %v", err)
+	}
+	if info, _ := os.Stat(dir + "/run.sh"); info.Mode().Perm() != 0640 {
+		t.Errorf("SetPermissions octal: expected 0640, got %o", info.Mode().Perm())
+	}
+	if _, err := tools.SetPermissions(tools.SetPermissionsParams{Path: "run.sh", Mode: "u+q"}, []string{dir}); err == nil {
+		t.Error("Expected error for invalid mode")
+	}
+
+	// Special bits are reported, and = clears them for the classes it assigns
+	res, err = tools.SetPermissions(tools.SetPermissionsParams{Path: "run.sh", Mode: "4755"}, []string{dir})
+	if err != nil || res["permissions"] != "4755" {
+		t.Errorf("SetPermissions setuid: %v, %v", res, err)
+	}
+	res, err = tools.SetPermissions(tools.SetPermissionsParams{Path: "run.sh", Mode: "u=rwx"}, []string{dir})
+	if err != nil || res["permissions"] != "0755" {
+		t.Errorf("SetPermissions u=rwx should clear setuid: %v, %v", res, err)
+	}
+
+	// Symbolic links are refused rather than silently left unchanged
+	os.Symlink("run.sh", dir+"/link")
+	if _, err := tools.SetPermissions(tools.SetPermissionsParams{Path: "link", Mode: "0600"}, []string{dir}); err == nil {
+		t.Error("Expected error for a symbolic link")
+	}
+	os.Symlink("sub/a.txt", dir+"/tree/link")
+	res, err = tools.SetPermissions(tools.SetPermissionsParams{Path: "tree", Mode: "u+r", Recursive: true}, []string{dir})
+	if err != nil || res["entries"].(int) != 3 {
+		t.Errorf("SetPermissions should not count links: %v, %v", res, err)
+	}
+}
+
+func TestSetOwnerAndTimes(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/f.txt", []byte("x"), 0644)
+
+	if _, err := tools.SetOwner(tools.SetOwnerParams{Path: "f.txt", User: "0"}, []string{dir}); err == nil {
+		t.Error("Expected error for set_owner with default policy")
+	}
+
+	mtime := time.Date(2021, 5, 6, 7, 8, 9, 0, time.UTC)
+	_, err := tools.SetTimes(tools.SetTimesParams{Path: "f.txt", Mtime: mtime.Format(time.RFC3339)}, []string{dir})
+	if err != nil {
+		t.Fatalf("SetTimes error: %v", err)
+	}
+	if info, _ := os.Stat(dir + "/f.txt"); !info.ModTime().Equal(mtime) {
+		t.Errorf("SetTimes: expected %v, got %v", mtime, info.ModTime())
+	}
+	if _, err := tools.SetTimes(tools.SetTimesParams{Path: "missing.txt"}, []string{dir}); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestReadOnlyRoots(t *testing.T) {
+	defer tools.SetPolicy(tools.DefaultPolicy())
+	ro, rw := t.TempDir(), t.TempDir()
+	dirs := []string{ro, rw}
+	os.WriteFile(ro+"/a.txt", []byte("a"), 0644)
+	if _, err := tools.ParseReadOnlyRoots(t.TempDir(), dirs); err == nil {
+		t.Error("Expected a read-only directory outside the allowed ones to be rejected")
+	}
+	roots, err := tools.ParseReadOnlyRoots(ro, dirs)
+	if err != nil {
+		t.Fatalf("ParseReadOnlyRoots: %v", err)
+	}
+	policy := tools.DefaultPolicy()
+	policy.ReadOnlyRoots = roots
+	tools.SetPolicy(policy)
+
+	if _, err := tools.ReadFile(tools.ReadFileParams{Path: ro + "/a.txt"}, dirs); err != nil {
+		t.Errorf("ReadFile in a read-only root: %v", err)
+	}
+	refused := map[string]func() (tools.ToolResult, error){
+		"write_file": func() (tools.ToolResult, error) {
+			return tools.WriteFile(tools.WriteFileParams{Path: ro + "/a.txt", Content: "b"}, dirs)
+		},
+		"set_permissions": func() (tools.ToolResult, error) {
+			return tools.SetPermissions(tools.SetPermissionsParams{Path: ro + "/a.txt", Mode: "u+x"}, dirs)
+		},
+		"set_times": func() (tools.ToolResult, error) {
+			return tools.SetTimes(tools.SetTimesParams{Path: ro + "/a.txt", Mtime: "now"}, dirs)
+		},
+		"create_symlink": func() (tools.ToolResult, error) {
+			return tools.CreateSymlink(tools.CreateSymlinkParams{Path: ro + "/l", Target: "a.txt"}, dirs)
+		},
+		"create_hardlink": func() (tools.ToolResult, error) {
+			return tools.CreateHardlink(tools.CreateHardlinkParams{Path: rw + "/h", Target: ro + "/a.txt"}, dirs)
+		},
+		"move_file": func() (tools.ToolResult, error) {
+			return tools.MoveFile(tools.MoveFileParams{Source: ro + "/a.txt", Destination: rw + "/a.txt"}, dirs)
+		},
+		"delete_file": func() (tools.ToolResult, error) {
+			return tools.DeleteFile(tools.DeleteFileParams{Path: ro + "/a.txt"}, dirs)
+		},
+	}
+	for name, fn := range refused {
+		if _, err := fn(); err == nil || !strings.Contains(err.Error(), "read-only") {
+			t.Errorf("%s in a read-only root: %v", name, err)
+		}
+	}
+	// A link from a writable root does not open a way in.
+	os.Symlink(ro+"/a.txt", rw+"/link")
+	if _, err := tools.WriteFile(tools.WriteFileParams{Path: rw + "/link", Content: "b"}, dirs); err == nil {
+		t.Error("Expected a write through a link into a read-only root to be refused")
+	}
+	if data, _ := os.ReadFile(ro + "/a.txt"); string(data) != "a" {
+		t.Errorf("Read-only file changed: %q", data)
+	}
+	if _, err := tools.WriteFile(tools.WriteFileParams{Path: rw + "/b.txt", Content: "b"}, dirs); err != nil {
+		t.Errorf("WriteFile in a writable root: %v", err)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	os.WriteFile(dir+"/target.txt", []byte("inside"), 0644)
+	os.WriteFile(outside+"/secret.txt", []byte("secret"), 0644)
+
+	_, err := tools.CreateSymlink(tools.CreateSymlinkParams{Path: "link.txt", Target: "target.txt"}, []string{dir})
+	if err != nil {
+		t.Fatalf("CreateSymlink error: %v", err)
+	}
+	res, err := tools.ReadFile(tools.ReadFileParams{Path: "link.txt"}, []string{dir})
+	if err != nil || res["content"].(string) != "inside" {
+		t.Errorf("ReadFile through symlink: %v, %v", res, err)
+	}
+	res, err = tools.ReadLink(tools.ReadLinkParams{Path: "link.txt"}, []string{dir})
+	if err != nil || res["target"].(string) != "target.txt" || !res["withinAllowed"].(bool) {
+		t.Errorf("ReadLink: unexpected result %v, %v", res, err)
+	}
+	res, err = tools.GetFileInfo(tools.GetFileInfoParams{Path: "link.txt"}, []string{dir})
+	if err != nil || !res["isSymlink"].(bool) || res["linkTarget"].(string) != "target.txt" {
+		t.Errorf("GetFileInfo on symlink: unexpected result %v, %v", res, err)
+	}
+
+	if _, err := tools.CreateSymlink(tools.CreateSymlinkParams{Path: "escape", Target: outside + "/secret.txt"}, []string{dir}); err == nil {
+		t.Error("Expected error for symlink target outside allowed directories")
+	}
+	if _, err := tools.CreateSymlink(tools.CreateSymlinkParams{Path: "escape", Target: "../" + filepath.Base(outside)}, []string{dir}); err == nil {
+		t.Error("Expected error for relative symlink target escaping allowed directories")
+	}
+
+	// Relative targets are resolved from the link's real directory, and ".."
+	// after a link applies to where the link leads.
+	os.MkdirAll(dir+"/x", 0755)
+	os.Symlink(dir, dir+"/x/y")
+	os.WriteFile(filepath.Dir(dir)+"/secret", []byte("secret"), 0644)
+	defer os.Remove(filepath.Dir(dir) + "/secret")
+	if _, err := tools.CreateSymlink(tools.CreateSymlinkParams{Path: "x/y/l", Target: "../secret"}, []string{dir}); err == nil {
+		t.Error("Expected a relative target escaping through a linked parent to be refused")
+	}
+	if _, err := tools.CreateSymlink(tools.CreateSymlinkParams{Path: "x/l", Target: "y/../secret"}, []string{dir}); err == nil {
+		t.Error("Expected \"..\" after a link to be resolved from the link's target")
+	}
+	if _, err := tools.CreateSymlink(tools.CreateSymlinkParams{Path: "x/y/ok", Target: "target.txt"}, []string{dir}); err != nil {
+		t.Errorf("CreateSymlink below a linked parent: %v", err)
+	}
+	os.RemoveAll(dir + "/x")
+	os.Remove(dir + "/ok")
+
+	// Links planted outside the server are refused when followed, but can be removed
+	os.Symlink(outside, dir+"/planted")
+	if _, err := tools.ReadFile(tools.ReadFileParams{Path: "planted/secret.txt"}, []string{dir}); err == nil {
+		t.Error("Expected error when following a symlink outside allowed directories")
+	}
+	if _, err := tools.WriteFile(tools.WriteFileParams{Path: "planted/new.txt", Content: "x"}, []string{dir}); err == nil {
+		t.Error("Expected error when writing through a symlink outside allowed directories")
+	}
+	if _, err := tools.DeleteFile(tools.DeleteFileParams{Path: "planted"}, []string{dir}); err != nil {
+		t.Errorf("DeleteFile on symlink error: %v", err)
+	}
+	if _, err := os.Stat(outside + "/secret.txt"); err != nil {
+		t.Error("Deleting a symlink removed its target")
+	}
+
+	_, err = tools.CreateHardlink(tools.CreateHardlinkParams{Path: "hard.txt", Target: "target.txt"}, []string{dir})
+	if err != nil {
+		t.Fatalf("CreateHardlink error: %v", err)
+	}
+	a, _ := os.Stat(dir + "/target.txt")
+	b, _ := os.Stat(dir + "/hard.txt")
+	if !os.SameFile(a, b) {
+		t.Error("CreateHardlink: files are not the same")
+	}
+}
+
+func TestEditFileMultiline(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.go", []byte("func a() {\n\treturn 1\n}\n\nfunc b() {\n\treturn 1\n}\n"), 0644)
+
+	// Ambiguous matches are refused and nothing is written
+	_, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{{OldText: "\treturn 1\n", NewText: "\treturn 2\n"}}}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "lines 2, 6") {
+		t.Errorf("Expected ambiguity error listing lines, got %v", err)
+	}
+
+	res, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{
+		{OldText: "func b() {\n\treturn 1\n}", NewText: "func b() int {\n\treturn 2\n}", Occurrence: 1},
+	}}, []string{dir})
+	if err != nil {
+		t.Fatalf("EditFile error: %v", err)
+	}
+	if diff := res["diff"].(string); !strings.Contains(diff, "@@ -2,6 +2,6 @@\n \treturn 1\n }\n \n-func b() {\n") {
+		t.Errorf("Unexpected diff: %q", diff)
+	}
+
+	res, err = tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{
+		{OldText: "return", NewText: "return -", ReplaceAll: true},
+	}, DryRun: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("EditFile replaceAll error: %v", err)
+	}
+	if res["preview"].(string) != "func a() {\n\treturn - 1\n}\n\nfunc b() int {\n\treturn - 2\n}\n" {
+		t.Errorf("Unexpected preview: %q", res["preview"])
+	}
+	if data, _ := os.ReadFile(dir + "/a.go"); strings.Contains(string(data), "-") {
+		t.Error("Dry run modified the file")
+	}
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{{OldText: "missing", NewText: "x"}}}, []string{dir}); err == nil {
+		t.Error("Expected error for missing oldText")
+	}
+}
+
+func TestEditFileWhitespaceMatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.go", []byte("func a() {\n\tif x {\n\t\treturn 1\n\t}\n}\n"), 0644)
+
+	edit := tools.EditOperation{OldText: "if x {\n    return 1\n}  \n", NewText: "if y {\n    return 2\n}\n"}
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir}); err == nil {
+		t.Error("Expected exact match to fail")
+	}
+	edit.Match = "whitespace"
+	res, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir})
+	if err != nil {
+		t.Fatalf("EditFile error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/a.go"); string(data) != "func a() {\n\tif y {\n\t\treturn 2\n\t}\n}\n" {
+		t.Errorf("Unexpected content: %q", data)
+	}
+	report := res["edits"].([]map[string]interface{})[0]
+	if report["normalization"] != "indentation" || report["confidence"].(float64) != 0.9 || report["reindented"] != true {
+		t.Errorf("Unexpected match report: %v", report)
+	}
+
+	// Spacing inside lines is a low-confidence match
+	edit = tools.EditOperation{OldText: "if  y {", NewText: "if z {", Match: "whitespace"}
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir}); err == nil || !strings.Contains(err.Error(), "confidence") {
+		t.Errorf("Expected low confidence error, got %v", err)
+	}
+	edit.MinConfidence = 0.5
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir}); err != nil {
+		t.Errorf("Expected match with lower minConfidence, got %v", err)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.txt", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"), 0644)
+
+	zero := 0
+	res, err := tools.EditFile(tools.EditFileParams{Path: "a.txt", Edits: []tools.EditOperation{
+		{OldText: "2\n", NewText: "two\n"},
+		{OldText: "9\n", NewText: "9"},
+	}, DryRun: true, ContextLines: &zero}, []string{dir})
+	if err != nil {
+		t.Fatalf("EditFile error: %v", err)
+	}
+	want := "--- a/a.txt\n+++ b/a.txt\n@@ -2 +2 @@\n-2\n+two\n@@ -9 +9 @@\n-9\n+9\n\\ No newline at end of file\n"
+	if res["diff"] != want {
+		t.Errorf("EditFile diff:\n%s\nwant:\n%s", res["diff"], want)
+	}
+
+	res, err = tools.WriteFile(tools.WriteFileParams{Path: "a.txt", Content: "1\n2\n3\n4\nfour and a half\n5\n6\n7\n8\n9\n", DryRun: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	want = "--- a/a.txt\n+++ b/a.txt\n@@ -2,6 +2,7 @@\n 2\n 3\n 4\n+four and a half\n 5\n 6\n 7\n"
+	if res["diff"] != want {
+		t.Errorf("WriteFile diff:\n%s\nwant:\n%s", res["diff"], want)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); strings.Contains(string(data), "half") {
+		t.Error("Dry run wrote the file")
+	}
+
+	res, err = tools.WriteFile(tools.WriteFileParams{Path: "new.txt", Content: "x\n", DryRun: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("WriteFile error: %v", err)
+	}
+	if res["diff"] != "--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+x\n" {
+		t.Errorf("WriteFile new file diff: %q", res["diff"])
+	}
+	if _, err := os.Stat(dir + "/new.txt"); !os.IsNotExist(err) {
+		t.Error("Dry run created the file")
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.txt", []byte("extra\n1\n2\n3\n4\n5\n6\n"), 0644)
+	os.WriteFile(dir+"/old.txt", []byte("keep\n"), 0755)
+	os.Chmod(dir+"/old.txt", 0755)
+	os.WriteFile(dir+"/gone.txt", []byte("bye\n"), 0644)
+	patch := `diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ 1
+-2
++two
+ 3
+@@ -5,2 +5,2 @@
+ 5
+-6
++six
+diff --git a/old.txt b/sub/new.txt
+similarity index 100%
+rename from old.txt
+rename to sub/new.txt
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+diff --git a/created.txt b/created.txt
+new file mode 100644
+--- /dev/null
++++ b/created.txt
+@@ -0,0 +1,2 @@
++hello
++world
+\ No newline at end of file
+`
+	res, err := tools.ApplyPatch(tools.ApplyPatchParams{Patch: patch, DryRun: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("ApplyPatch dry run error: %v", err)
+	}
+	if !res["ok"].(bool) {
+		t.Fatalf("ApplyPatch dry run rejected: %v", res)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); strings.Contains(string(data), "two") {
+		t.Error("Dry run modified a file")
+	}
+
+	res, err = tools.ApplyPatch(tools.ApplyPatchParams{Patch: patch}, []string{dir})
+	if err != nil || !res["ok"].(bool) {
+		t.Fatalf("ApplyPatch: %v %v", res, err)
+	}
+	hunk := res["files"].([]map[string]interface{})[0]["hunks"].([]map[string]interface{})[0]
+	if hunk["offset"] != 1 {
+		t.Errorf("Expected offset 1, got %v", hunk)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); string(data) != "extra\n1\ntwo\n3\n4\n5\nsix\n" {
+		t.Errorf("Unexpected a.txt: %q", data)
+	}
+	if data, _ := os.ReadFile(dir + "/sub/new.txt"); string(data) != "keep\n" {
+		t.Errorf("Rename failed: %q", data)
+	}
+	if info, err := os.Stat(dir + "/sub/new.txt"); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Rename did not keep the mode: %v", info)
+	}
+	if data, _ := os.ReadFile(dir + "/created.txt"); string(data) != "hello\nworld" {
+		t.Errorf("Create failed: %q", data)
+	}
+	for _, p := range []string{"/old.txt", "/gone.txt"} {
+		if _, err := os.Stat(dir + p); !os.IsNotExist(err) {
+			t.Errorf("%s should be gone", p)
+		}
+	}
+
+	// A rejected hunk leaves every file untouched
+	bad := "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-extra\n+EXTRA\n--- a/sub/new.txt\n+++ b/sub/new.txt\n@@ -1 +1 @@\n-missing\n+x\n"
+	res, err = tools.ApplyPatch(tools.ApplyPatchParams{Patch: bad}, []string{dir})
+	if err != nil {
+		t.Fatalf("ApplyPatch error: %v", err)
+	}
+	if res["ok"].(bool) {
+		t.Error("Expected rejection")
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); strings.Contains(string(data), "EXTRA") {
+		t.Error("Rejected patch modified a file")
+	}
+	res, _ = tools.ApplyPatch(tools.ApplyPatchParams{Patch: bad, AllowPartial: true}, []string{dir})
+	if data, _ := os.ReadFile(dir + "/a.txt"); !strings.HasPrefix(string(data), "EXTRA\n") {
+		t.Errorf("allowPartial did not apply the good file: %v", res)
+	}
+
+	// Several sections for one file apply in order to the same text.
+	os.WriteFile(dir+"/f.txt", []byte("1\n2\n3\n4\n5\n6\n7\n8\n9\n"), 0644)
+	twice := "--- a/f.txt\n+++ b/f.txt\n@@ -1,2 +1,2 @@\n-1\n+one\n 2\n" +
+		"--- a/f.txt\n+++ b/f.txt\n@@ -8,2 +8,2 @@\n 8\n-9\n+nine\n"
+	res, err = tools.ApplyPatch(tools.ApplyPatchParams{Patch: twice}, []string{dir})
+	if err != nil || !res["ok"].(bool) {
+		t.Fatalf("ApplyPatch with two sections for a file: %v %v", res, err)
+	}
+	if data, _ := os.ReadFile(dir + "/f.txt"); string(data) != "one\n2\n3\n4\n5\n6\n7\n8\nnine\n" {
+		t.Errorf("Two sections for a file: %q", data)
+	}
+
+	// A plain diff between differently named files patches the new name.
+	os.WriteFile(dir+"/g.txt", []byte("a\nb\n"), 0644)
+	plain := "--- g.txt.orig\t2025-01-01 00:00:00\n+++ g.txt\t2025-01-02 00:00:00\n@@ -1,2 +1,2 @@\n a\n-b\n+B\n"
+	res, err = tools.ApplyPatch(tools.ApplyPatchParams{Patch: plain}, []string{dir})
+	if err != nil || !res["ok"].(bool) {
+		t.Fatalf("ApplyPatch of a plain diff: %v %v", res, err)
+	}
+	if data, _ := os.ReadFile(dir + "/g.txt"); string(data) != "a\nB\n" {
+		t.Errorf("Plain diff: %q", data)
+	}
+}
+
+func TestLineEdits(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.txt", []byte("1\n2\n3\n4\n5\n"), 0644)
+
+	res, err := tools.ReadFile(tools.ReadFileParams{Path: "a.txt", StartLine: 2, EndLine: 3}, []string{dir})
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	if res["content"] != "2\n3\n" || res["totalLines"] != 5 {
+		t.Errorf("Unexpected range: %v", res)
+	}
+	if tail, _ := tools.ReadFile(tools.ReadFileParams{Path: "a.txt", Tail: 2}, []string{dir}); tail["content"] != "4\n5\n" || tail["startLine"] != 4 {
+		t.Errorf("Unexpected tail: %v", tail)
+	}
+	hash := res["hash"].(string)
+
+	edits := []tools.EditOperation{
+		{Op: "insert", Line: 1, NewText: "1.5"},
+		{Op: "delete", StartLine: 3},
+		{Op: "replace", StartLine: 4, EndLine: 5, NewText: "four\nfive\n"},
+		{Op: "insertBefore", Pattern: "^1", NewText: "0"},
+	}
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.txt", Edits: edits}, []string{dir}); err == nil {
+		t.Error("Expected error without expectedHash")
+	}
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.txt", Edits: edits, ExpectedHash: hash}, []string{dir}); err == nil {
+		t.Error("Expected ambiguous pattern error")
+	}
+	edits[3].Pattern = "^1$"
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.txt", Edits: edits, ExpectedHash: hash}, []string{dir}); err != nil {
+		t.Fatalf("EditFile error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/a.txt"); string(data) != "0\n1\n1.5\n2\nfour\nfive\n" {
+		t.Errorf("Unexpected content: %q", data)
+	}
+
+	// The old hash is now stale
+	_, err = tools.EditFile(tools.EditFileParams{Path: "a.txt", Edits: []tools.EditOperation{{Op: "delete", StartLine: 1}}, ExpectedHash: hash}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("Expected stale hash error, got %v", err)
+	}
+}
+
+func TestEditFileRegex(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/a.go", []byte("func Load(path string) {}\nfunc Save(path string) {}\nfunc other() {}\n"), 0644)
+
+	edit := tools.EditOperation{OldText: `^func ([A-Z]\w*)\(path string\)`, NewText: "func ${1}File(path string, mode int)", Regex: true, Multiline: true}
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir}); err == nil {
+		t.Error("Expected ambiguity error without replaceAll")
+	}
+	edit.ReplaceAll, edit.MaxReplacements = true, 1
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir}); err == nil || !strings.Contains(err.Error(), "maxReplacements") {
+		t.Errorf("Expected maxReplacements error, got %v", err)
+	}
+	edit.MaxReplacements = 0
+	res, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir})
+	if err != nil {
+		t.Fatalf("EditFile error: %v", err)
+	}
+	if data, _ := os.ReadFile(dir + "/a.go"); string(data) != "func LoadFile(path string, mode int) {}\nfunc SaveFile(path string, mode int) {}\nfunc other() {}\n" {
+		t.Errorf("Unexpected content: %q", data)
+	}
+	matches := res["edits"].([]map[string]interface{})[0]["matches"].([]map[string]interface{})
+	if len(matches) != 2 || matches[1]["line"] != 2 {
+		t.Errorf("Unexpected matches report: %v", matches)
+	}
+
+	// Several matches on one line share a hunk but count separately
+	os.WriteFile(dir+"/b.txt", []byte("x x x\n"), 0644)
+	res, err = tools.EditFile(tools.EditFileParams{Path: "b.txt", Edits: []tools.EditOperation{{OldText: "x", NewText: "y", Regex: true, ReplaceAll: true}}}, []string{dir})
+	if err != nil {
+		t.Fatalf("EditFile error: %v", err)
+	}
+	if n := res["edits"].([]map[string]interface{})[0]["replacements"]; n != 3 {
+		t.Errorf("Expected 3 replacements, got %v", n)
+	}
+
+	edit = tools.EditOperation{OldText: "OTHER", NewText: "Other", Regex: true, CaseInsensitive: true}
+	if _, err := tools.EditFile(tools.EditFileParams{Path: "a.go", Edits: []tools.EditOperation{edit}}, []string{dir}); err != nil {
+		t.Errorf("Case-insensitive regex error: %v", err)
+	}
+}
+
+func TestReplaceInFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/pkg", 0755)
+	os.MkdirAll(dir+"/vendor", 0755)
+	os.WriteFile(dir+"/main.go", []byte("oldName()\noldName()\n"), 0644)
+	os.WriteFile(dir+"/pkg/a.go", []byte("func oldName() {}\n"), 0644)
+	os.WriteFile(dir+"/vendor/v.go", []byte("oldName()\n"), 0644)
+	os.WriteFile(dir+"/notes.txt", []byte("oldName\n"), 0644)
+	os.WriteFile(dir+"/bin.go", []byte("oldName\x00"), 0644)
+
+	params := tools.ReplaceInFilesParams{Path: ".", Include: []string{"*.go"}, Exclude: []string{"vendor"}, Pattern: `old(Name)\(`, Replacement: "new$1(", Regex: true, DryRun: true}
+	res, err := tools.ReplaceInFiles(params, []string{dir})
+	if err != nil {
+		t.Fatalf("ReplaceInFiles dry run error: %v", err)
+	}
+	if res["filesChanged"] != 2 || res["replacements"] != 3 {
+		t.Fatalf("Unexpected plan: %v", res)
+	}
+	hashes := map[string]string{}
+	for _, f := range res["files"].([]map[string]interface{}) {
+		hashes[f["path"].(string)] = f["hash"].(string)
+	}
+	if data, _ := os.ReadFile(dir + "/main.go"); strings.Contains(string(data), "new") {
+		t.Error("Dry run wrote a file")
+	}
+
+	// A file changed after the preview blocks the whole apply
+	os.WriteFile(dir+"/pkg/a.go", []byte("func oldName() int {}\n"), 0644)
+	params.DryRun, params.ExpectedHashes = false, hashes
+	res, err = tools.ReplaceInFiles(params, []string{dir})
+	if err != nil {
+		t.Fatalf("ReplaceInFiles error: %v", err)
+	}
+	if res["ok"].(bool) {
+		t.Fatal("Expected stale file to block the apply")
+	}
+	if data, _ := os.ReadFile(dir + "/main.go"); strings.Contains(string(data), "new") {
+		t.Error("Stale apply wrote a file")
+	}
+
+	params.ExpectedHashes = nil
+	res, err = tools.ReplaceInFiles(params, []string{dir})
+	if err != nil || !res["ok"].(bool) {
+		t.Fatalf("ReplaceInFiles: %v %v", res, err)
+	}
+	if data, _ := os.ReadFile(dir + "/main.go"); string(data) != "newName()\nnewName()\n" {
+		t.Errorf("Unexpected main.go: %q", data)
+	}
+	for _, p := range []string{"/vendor/v.go", "/notes.txt"} {
+		if data, _ := os.ReadFile(dir + p); strings.Contains(string(data), "new") {
+			t.Errorf("%s should not have been changed", p)
+		}
+	}
+
+	// A plan refuses files that changed after it was made
+	plan, err := tools.PlanReplaceInFiles(tools.ReplaceInFilesParams{Path: ".", Pattern: "newName", Replacement: "finalName"}, []string{dir})
+	if err != nil || len(plan.Paths()) != 2 {
+		t.Fatalf("PlanReplaceInFiles: %v %v", plan, err)
+	}
+	os.WriteFile(dir+"/main.go", []byte("newName()\n"), 0644)
+	res, err = plan.Apply([]string{dir})
+	if err != nil || res["ok"].(bool) || len(res["stale"].([]string)) != 1 {
+		t.Errorf("Expected the changed file to be stale: %v %v", res, err)
+	}
+}
+
+func TestToolSchemas(t *testing.T) {
+	var schema map[string]interface{}
+	raw, err := tools.InputSchema(tools.EditFileParams{})
+	if err != nil || json.Unmarshal(raw, &schema) != nil {
+		t.Fatalf("InputSchema error: %v", err)
+	}
+	edits := schema["properties"].(map[string]interface{})["edits"].(map[string]interface{})
+	if edits["type"] != "array" {
+		t.Fatalf("edits should be an array: %v", edits)
+	}
+	item := edits["items"].(map[string]interface{})
+	props := item["properties"].(map[string]interface{})
+	if props["occurrence"].(map[string]interface{})["type"] != "integer" {
+		t.Errorf("occurrence should be an integer: %v", props["occurrence"])
+	}
+	if props["minConfidence"].(map[string]interface{})["default"] != 0.8 {
+		t.Errorf("minConfidence default missing: %v", props["minConfidence"])
+	}
+	if item["additionalProperties"] != false {
+		t.Error("edit objects should not allow unknown properties")
+	}
+	raw, _ = tools.InputSchema(tools.ReadMultipleFilesParams{})
+	schema = nil
+	json.Unmarshal(raw, &schema)
+	if req, _ := schema["required"].([]interface{}); len(req) != 1 || req[0] != "paths" {
+		t.Errorf("read_multiple_files should require paths: %v", schema["required"])
+	}
+
+	var params tools.EditFileParams
+	ok := map[string]interface{}{
+		"path":  "a.txt",
+		"edits": []interface{}{map[string]interface{}{"oldText": "a", "newText": "b", "occurrence": 2.0}},
+	}
+	if err := decodeParams(ok, &params); err != nil {
+		t.Fatalf("decodeParams error: %v", err)
+	}
+	if params.Edits[0].Occurrence != 2 {
+		t.Errorf("Unexpected params: %+v", params)
+	}
+	bad := []struct {
+		args map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"path": "a", "edits": []interface{}{}, "dryrun": true}, `unknown parameter "dryrun"`},
+		{map[string]interface{}{"path": "a", "edits": []interface{}{"old"}}, `"edits[0]" must be an object, got string`},
+		{map[string]interface{}{"path": "a", "edits": []interface{}{map[string]interface{}{"old": "x"}}}, `unknown parameter "edits[0].old"`},
+		{map[string]interface{}{"path": "a", "edits": []interface{}{map[string]interface{}{"occurrence": 1.5}}}, `must be an integer, got number`},
+		{map[string]interface{}{"path": "a", "edits": []interface{}{map[string]interface{}{"match": "fuzzy"}}}, `must be one of exact, whitespace`},
+		{map[string]interface{}{"edits": []interface{}{}}, `missing required parameter "path"`},
+	}
+	for _, c := range bad {
+		err := decodeParams(c.args, &params)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("decodeParams(%v) = %v, want %q", c.args, err, c.want)
+		}
+	}
+	if err := decodeParams(map[string]interface{}{"path": "a", "edits": []interface{}{}, "dryRun": nil}, &params); err != nil {
+		t.Errorf("null should be treated as absent: %v", err)
+	}
+
+	// An empty optional enum means its default; a required one must be set
+	if err := decodeParams(map[string]interface{}{"path": "a", "edits": []interface{}{map[string]interface{}{"oldText": "x", "match": ""}}}, &params); err != nil {
+		t.Errorf("empty optional enum rejected: %v", err)
+	}
+	if err := tools.ValidateArgs(map[string]interface{}{"path": "x", "sortBy": ""}, tools.ListDirectoryWithSizesParams{}); err != nil {
+		t.Errorf("empty sortBy rejected: %v", err)
+	}
+	if err := tools.ValidateArgs(map[string]interface{}{"operations": []interface{}{map[string]interface{}{"op": "", "path": "x"}}}, tools.BatchParams{}); err == nil {
+		t.Error("empty required enum accepted")
+	}
+}
+
+func TestJSONEditing(t *testing.T) {
+	dir := t.TempDir()
+	orig := "{\r\n    \"name\": \"app\",\r\n    \"version\": \"1.0.0\",\r\n    \"scripts\": {\r\n        \"build\": \"go build\"\r\n    },\r\n    \"files\": [\"a\", \"b\"]\r\n}\r\n"
+	os.WriteFile(dir+"/package.json", []byte(orig), 0644)
+
+	res, err := tools.JSONGet(tools.JSONGetParams{Path: "package.json", Pointer: "/scripts/build"}, []string{dir})
+	if err != nil {
+		t.Fatalf("JSONGet error: %v", err)
+	}
+	if string(res["value"].(json.RawMessage)) != `"go build"` || res["type"] != "string" {
+		t.Errorf("Unexpected value: %v", res)
+	}
+
+	_, err = tools.JSONSet(tools.JSONSetParams{Path: "package.json", Pointer: "/scripts/test", Value: json.RawMessage(`"go test ./..."`)}, []string{dir})
+	if err != nil {
+		t.Fatalf("JSONSet error: %v", err)
+	}
+	_, err = tools.JSONSet(tools.JSONSetParams{Path: "package.json", Pointer: "/config/env/debug", Value: json.RawMessage(`true`), CreateParents: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("JSONSet error: %v", err)
+	}
+	_, err = tools.JSONDelete(tools.JSONDeleteParams{Path: "package.json", Pointer: "/version"}, []string{dir})
+	if err != nil {
+		t.Fatalf("JSONDelete error: %v", err)
+	}
+	data, _ := os.ReadFile(dir + "/package.json")
+	want := "{\r\n    \"name\": \"app\",\r\n    \"scripts\": {\r\n        \"build\": \"go build\",\r\n        \"test\": \"go test ./...\"\r\n    },\r\n" +
+		"    \"files\": [\"a\", \"b\"],\r\n    \"config\": {\r\n        \"env\": {\r\n            \"debug\": true\r\n        }\r\n    }\r\n}\r\n"
+	if string(data) != want {
+		t.Errorf("Unexpected file:\n%q\nwant\n%q", data, want)
+	}
+
+	// A failing test operation leaves the file unchanged
+	patch := []tools.JSONPatchOperation{
+		{Op: "add", Path: "/files/-", Value: json.RawMessage(`"c"`)},
+		{Op: "test", Path: "/name", Value: json.RawMessage(`"other"`)},
+	}
+	if _, err := tools.JSONPatch(tools.JSONPatchParams{Path: "package.json", Operations: patch}, []string{dir}); err == nil {
+		t.Fatal("Expected the test operation to fail")
+	}
+	if now, _ := os.ReadFile(dir + "/package.json"); string(now) != want {
+		t.Error("Failed patch changed the file")
+	}
+
+	patch[1].Value = json.RawMessage(`"app"`)
+	patch = append(patch,
+		tools.JSONPatchOperation{Op: "move", From: "/config", Path: "/settings"},
+		tools.JSONPatchOperation{Op: "copy", From: "/files/0", Path: "/files/0"},
+		tools.JSONPatchOperation{Op: "replace", Path: "/name", Value: json.RawMessage(`"<app>"`)},
+		tools.JSONPatchOperation{Op: "remove", Path: "/scripts"},
+	)
+	res, err = tools.JSONPatch(tools.JSONPatchParams{Path: "package.json", Operations: patch}, []string{dir})
+	if err != nil {
+		t.Fatalf("JSONPatch error: %v", err)
+	}
+	if !res["changed"].(bool) {
+		t.Error("Expected JSONPatch to report a change")
+	}
+	res, _ = tools.JSONGet(tools.JSONGetParams{Path: "package.json"}, []string{dir})
+	if got := string(res["value"].(json.RawMessage)); got != `{"name":"<app>","files":["a","a","b","c"],"settings":{"env":{"debug":true}}}` {
+		t.Errorf("Unexpected document: %s", got)
+	}
+
+	os.WriteFile(dir+"/broken.json", []byte("{\n  \"a\": 1,\n  \"b\" 2\n}\n"), 0644)
+	_, err = tools.JSONGet(tools.JSONGetParams{Path: "broken.json"}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected a syntax error on line 3, got %v", err)
+	}
+}
+
+func TestSyntaxCheck(t *testing.T) {
+	dir := t.TempDir()
+	defer tools.SetPolicy(tools.DefaultPolicy())
+	policy := tools.DefaultPolicy()
+	policy.SyntaxCheckExtensions, _ = tools.ParseSyntaxCheckExtensions(".go,json,.xml")
+	tools.SetPolicy(policy)
+
+	_, err := tools.WriteFile(tools.WriteFileParams{Path: "main.go", Content: "package main\n\nfunc main() {\n\tx :=\n}\n"}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "invalid Go at line 5, column 1") {
+		t.Errorf("Expected a Go syntax error, got %v", err)
+	}
+	if _, err := os.Stat(dir + "/main.go"); !os.IsNotExist(err) {
+		t.Error("Invalid Go file was written")
+	}
+	_, err = tools.WriteFile(tools.WriteFileParams{Path: "a.xml", Content: "<a>\n  <b></a>\n"}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "invalid XML at line 2") {
+		t.Errorf("Expected an XML syntax error, got %v", err)
+	}
+	if _, err := tools.WriteFile(tools.WriteFileParams{Path: "notes.txt", Content: "{"}, []string{dir}); err != nil {
+		t.Errorf("Unchecked extension was refused: %v", err)
+	}
+
+	os.WriteFile(dir+"/c.json", []byte("{\n  \"a\": 1\n}\n"), 0644)
+	_, err = tools.EditFile(tools.EditFileParams{Path: "c.json", Edits: []tools.EditOperation{{OldText: "1", NewText: "1,"}}}, []string{dir})
+	if err == nil || !strings.Contains(err.Error(), "invalid JSON at line 2") {
+		t.Errorf("Expected a JSON syntax error, got %v", err)
+	}
+	patch := "--- a/c.json\n+++ b/c.json\n@@ -1,3 +1,3 @@\n {\n-  \"a\": 1\n+  \"a\": \n }\n"
+	res, err := tools.ApplyPatch(tools.ApplyPatchParams{Patch: patch}, []string{dir})
+	if err != nil {
+		t.Fatalf("ApplyPatch error: %v", err)
+	}
+	files := res["files"].([]map[string]interface{})
+	if res["ok"].(bool) || files[0]["syntaxError"] == nil {
+		t.Errorf("Expected the patch to be rejected: %v", res)
+	}
+	if data, _ := os.ReadFile(dir + "/c.json"); string(data) != "{\n  \"a\": 1\n}\n" {
+		t.Errorf("Rejected changes were written: %q", data)
+	}
+
+	policy.SyntaxCheckWarnOnly = true
+	tools.SetPolicy(policy)
+	res, err = tools.WriteFile(tools.WriteFileParams{Path: "main.go", Content: "package main\n\nfunc main() {\n"}, []string{dir})
+	if err != nil || res["syntaxWarning"] == nil {
+		t.Errorf("Expected a warning: %v %v", res, err)
+	}
+	if _, err := os.Stat(dir + "/main.go"); err != nil {
+		t.Error("Warn mode should still write the file")
+	}
+
+	if _, err := tools.ParseSyntaxCheckExtensions(".yaml"); err == nil {
+		t.Error("Expected unsupported extension to be rejected")
+	}
+}
+
+func TestFormatFile(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/main.go", []byte("package main\r\nfunc main(){\r\nx:=1\r\n_=x}\r\n"), 0644)
+	res, err := tools.FormatFile(tools.FormatFileParams{Path: "main.go"}, []string{dir})
+	if err != nil || !res["changed"].(bool) {
+		t.Fatalf("FormatFile: %v %v", res, err)
+	}
+	if data, _ := os.ReadFile(dir + "/main.go"); string(data) != "package main\r\n\r\nfunc main() {\r\n\tx := 1\r\n\t_ = x\r\n}\r\n" {
+		t.Errorf("Unexpected Go output: %q", data)
+	}
+
+	os.WriteFile(dir+"/a.json", []byte(`{"b":[1,2],"a":{}}`), 0644)
+	res, err = tools.FormatFile(tools.FormatFileParams{Path: "a.json", Indent: "\t", DryRun: true}, []string{dir})
+	if err != nil {
+		t.Fatalf("FormatFile error: %v", err)
+	}
+	if res["content"] != "{\n\t\"b\": [\n\t\t1,\n\t\t2\n\t],\n\t\"a\": {}\n}\n" {
+		t.Errorf("Unexpected JSON output: %q", res["content"])
+	}
+	if data, _ := os.ReadFile(dir + "/a.json"); string(data) != `{"b":[1,2],"a":{}}` {
+		t.Error("Dry run wrote the file")
+	}
+
+	os.WriteFile(dir+"/a.xml", []byte("<?xml version=\"1.0\"?>\n<root a=\"1\"><!-- c --><item>x &amp; y</item>\n<empty></empty><ns:b xmlns:ns=\"urn:x\"><c/></ns:b></root>"), 0644)
+	if _, err := tools.FormatFile(tools.FormatFileParams{Path: "a.xml"}, []string{dir}); err != nil {
+		t.Fatalf("FormatFile error: %v", err)
+	}
+	want := "<?xml version=\"1.0\"?>\n<root a=\"1\">\n  <!-- c -->\n  <item>x &amp; y</item>\n  <empty/>\n  <ns:b xmlns:ns=\"urn:x\">\n    <c/>\n  </ns:b>\n</root>\n"
+	if data, _ := os.ReadFile(dir + "/a.xml"); string(data) != want {
+		t.Errorf("Unexpected XML output:\n%s", data)
+	}
+
+	// Mixed content and xml:space="preserve" are kept as written.
+	os.WriteFile(dir+"/m.xml", []byte("<doc><p>Hello <b>world</b>, again</p><pre xml:space=\"preserve\">  a\n    <i>b</i></pre></doc>"), 0644)
+	if _, err := tools.FormatFile(tools.FormatFileParams{Path: "m.xml"}, []string{dir}); err != nil {
+		t.Fatalf("FormatFile error: %v", err)
+	}
+	want = "<doc>\n  <p>Hello <b>world</b>, again</p>\n  <pre xml:space=\"preserve\">  a\n    <i>b</i></pre>\n</doc>\n"
+	if data, _ := os.ReadFile(dir + "/m.xml"); string(data) != want {
+		t.Errorf("Unexpected XML output:\n%s", data)
+	}
+
+	os.WriteFile(dir+"/bad.go", []byte("package main\nfunc {\n"), 0644)
+	if _, err := tools.FormatFile(tools.FormatFileParams{Path: "bad.go"}, []string{dir}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected a syntax error on line 2, got %v", err)
+	}
+
+	// Format on write
+	defer tools.SetPolicy(tools.DefaultPolicy())
+	policy := tools.DefaultPolicy()
+	policy.FormatOnWriteExtensions, _ = tools.ParseFormatExtensions(".go")
+	tools.SetPolicy(policy)
+	res, err = tools.WriteFile(tools.WriteFileParams{Path: "gen.go", Content: "package gen\nvar X=1\n"}, []string{dir})
+	if err != nil || res["formatted"] != true {
+		t.Fatalf("WriteFile: %v %v", res, err)
+	}
+	if data, _ := os.ReadFile(dir + "/gen.go"); string(data) != "package gen\n\nvar X = 1\n" {
+		t.Errorf("Unexpected formatted file: %q", data)
+	}
+	res, err = tools.EditFile(tools.EditFileParams{Path: "gen.go", Edits: []tools.EditOperation{{OldText: "var X = 1", NewText: "var X=2"}}}, []string{dir})
+	if err != nil || res["formatted"] != true {
+		t.Fatalf("EditFile: %v %v", res, err)
+	}
+	if data, _ := os.ReadFile(dir + "/gen.go"); string(data) != "package gen\n\nvar X = 2\n" {
+		t.Errorf("Unexpected formatted file: %q", data)
+	}
+
+	// replace_in_files writes exactly what its diff showed
+	res, err = tools.ReplaceInFiles(tools.ReplaceInFilesParams{Path: ".", Include: []string{"gen.go"}, Pattern: "X = 2", Replacement: "X=3"}, []string{dir})
+	if err != nil || res["ok"] != true {
+		t.Fatalf("ReplaceInFiles: %v %v", res, err)
+	}
+	if data, _ := os.ReadFile(dir + "/gen.go"); string(data) != "package gen\n\nvar X=3\n" {
+		t.Errorf("replace_in_files reformatted the file: %q", data)
+	}
+}
+
+func TestGrepFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/src", 0755)
+	os.MkdirAll(dir+"/vendor", 0755)
+	os.WriteFile(dir+"/src/a.go", []byte("package a\n\n// TODO: fix\nfunc todo() {}\nvar x = \"TODO\"\n"), 0644)
+	os.WriteFile(dir+"/src/b.txt", []byte("TODO later\n"), 0644)
+	os.WriteFile(dir+"/vendor/v.go", []byte("// TODO vendored\n"), 0644)
+	os.WriteFile(dir+"/src/bin.go", []byte("TODO\x00binary"), 0644)
+
+	res, err := tools.GrepFiles(tools.GrepFilesParams{Path: ".", Pattern: "TODO", Include: []string{"*.go"}, Exclude: []string{"vendor"}, BeforeContext: 1, AfterContext: 1}, []string{dir})
+	if err != nil {
+		t.Fatalf("GrepFiles error: %v", err)
+	}
+	matches := res["matches"].([]map[string]interface{})
+	if len(matches) != 2 || res["filesSkipped"] != 1 {
+		t.Fatalf("Unexpected matches: %v", res)
+	}
+	m := matches[0]
+	if m["path"] != "src/a.go" || m["line"] != 3 || m["column"] != 4 || m["text"] != "// TODO: fix" {
+		t.Errorf("Unexpected match: %v", m)
+	}
+	if before := m["before"].([]string); len(before) != 1 || before[0] != "" {
+		t.Errorf("Unexpected context: %v", m["before"])
+	}
+	if after := m["after"].([]string); len(after) != 1 || after[0] != "func todo() {}" {
+		t.Errorf("Unexpected context: %v", m["after"])
+	}
+
+	res, _ = tools.GrepFiles(tools.GrepFilesParams{Path: "src", Pattern: "todo", CaseInsensitive: true, WholeWord: true, Include: []string{"*.go"}}, []string{dir})
+	if n := res["totalMatches"]; n != 3 {
+		t.Errorf("Expected 3 case-insensitive matches, got %v", n)
+	}
+	res, _ = tools.GrepFiles(tools.GrepFilesParams{Path: ".", Pattern: `TO+D`, Regex: true, MaxMatches: 2}, []string{dir})
+	if res["totalMatches"] != 2 || res["truncated"] != true {
+		t.Errorf("Expected the total limit to apply: %v", res)
+	}
+	res, _ = tools.GrepFiles(tools.GrepFilesParams{Path: "src", Pattern: "TODO", MaxMatchesPerFile: 1}, []string{dir})
+	if res["totalMatches"] != 2 || res["filesMatched"] != 2 {
+		t.Errorf("Expected one match per file: %v", res)
+	}
+	if _, err := tools.GrepFiles(tools.GrepFilesParams{Path: "/etc", Pattern: "root"}, []string{dir}); err == nil {
+		t.Error("Expected a path outside the roots to be refused")
+	}
+}
+
+func TestSearchFilesGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"src/a.go", "src/a_test.go", "src/x/y/b_test.go", "cmd/tool/main.go", "cmd/tool/util.go", "go.mod", "node_modules/m/c_test.go", "README.md"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0755)
+		os.WriteFile(filepath.Join(dir, p), []byte("x"), 0644)
+	}
+	search := func(pattern string, exclude ...string) []string {
+		res, err := tools.SearchFiles(tools.SearchFilesParams{Path: ".", Pattern: pattern, ExcludePatterns: exclude}, []string{dir})
+		if err != nil || res["isError"] != nil {
+			t.Fatalf("SearchFiles(%q): %v %v", pattern, res, err)
+		}
+		text := res["content"].([]map[string]interface{})[0]["text"].(string)
+		if text == "No matches found" {
+			return nil
+		}
+		return strings.Split(text, "\n")
+	}
+	cases := []struct {
+		pattern string
+		exclude []string
+		want    string
+	}{
+		{"src/**/*_test.go", nil, "src/a_test.go,src/x/y/b_test.go"},
+		{"cmd/*/main.go", nil, "cmd/tool/main.go"},
+		{"*.{go,mod}", []string{"**/node_modules", "src/**"}, "cmd/tool/main.go,cmd/tool/util.go,go.mod"},
+		{"*_test.go", []string{"node_modules/"}, "src/a_test.go,src/x/y/b_test.go"},
+		{"**/y", nil, "src/x/y"},
+		{"/go.mod", nil, "go.mod"},
+	}
+	for _, c := range cases {
+		if got := strings.Join(search(c.pattern, c.exclude...), ","); got != c.want {
+			t.Errorf("SearchFiles(%q, %v) = %q, want %q", c.pattern, c.exclude, got, c.want)
+		}
+	}
+	res, _ := tools.SearchFiles(tools.SearchFilesParams{Path: ".", Pattern: "[a-"}, []string{dir})
+	if res["isError"] != true {
+		t.Error("Expected a malformed pattern to be reported")
+	}
+}
+
+func TestSearchFilesMatchModes(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"docs/Config.md", "src/config/loader.go", "src/myconfig.go", "src/config.go", "src/cfg_group.go"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0755)
+		os.WriteFile(filepath.Join(dir, p), []byte("x"), 0644)
+	}
+	search := func(params tools.SearchFilesParams) []string {
+		params.Path = "."
+		res, err := tools.SearchFiles(params, []string{dir})
+		if err != nil || res["isError"] != nil {
+			t.Fatalf("SearchFiles(%+v): %v %v", params, res, err)
+		}
+		var got []string
+		for _, m := range res["matches"].([]map[string]interface{}) {
+			got = append(got, m["path"].(string)+":"+m["type"].(string))
+		}
+		return got
+	}
+	cases := []struct {
+		params tools.SearchFilesParams
+		want   string
+	}{
+		{tools.SearchFilesParams{Pattern: "config", MatchMode: "substring"},
+			"src/config:dir,docs/Config.md:file,src/config.go:file,src/myconfig.go:file"},
+		{tools.SearchFilesParams{Pattern: "Config", MatchMode: "substring", CaseSensitive: true}, "docs/Config.md:file"},
+		{tools.SearchFilesParams{Pattern: "CONFIG.*"}, "docs/Config.md:file,src/config.go:file"},
+		{tools.SearchFilesParams{Pattern: "CONFIG.*", CaseSensitive: true}, ""},
+		{tools.SearchFilesParams{Pattern: `^c.*\.go$`, MatchMode: "regex"}, "src/cfg_group.go:file,src/config.go:file"},
+		{tools.SearchFilesParams{Pattern: "src/config/", MatchMode: "substring"}, "src/config/loader.go:file"},
+		{tools.SearchFilesParams{Pattern: "cfg", MatchMode: "fuzzy"},
+			"src/cfg_group.go:file,docs/Config.md:file,src/config:dir,src/config.go:file,src/myconfig.go:file"},
+	}
+	for _, c := range cases {
+		if got := strings.Join(search(c.params), ","); got != c.want {
+			t.Errorf("SearchFiles(%q, %s) = %q, want %q", c.params.Pattern, c.params.MatchMode, got, c.want)
+		}
+	}
+	res, _ := tools.SearchFiles(tools.SearchFilesParams{Path: ".", Pattern: "(", MatchMode: "regex"}, []string{dir})
+	if res["isError"] != true {
+		t.Error("Expected an invalid regex to be reported")
+	}
+}
+
+func TestRespectGitignore(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".gitignore":          "# build output\nnode_modules/\n*.log\n!keep.log\n/dist\nbuild/\n..cache/\n",
+		".git/info/exclude":   "local.txt\n",
+		".git/HEAD":           "ref: refs/heads/main\n",
+		"src/.gitignore":      "gen/\n!important.log\n",
+		"src/.mcpignore":      "secret.go\n",
+		"src/main.go":         "x",
+		"src/secret.go":       "x",
+		"src/gen/out.go":      "x",
+		"src/important.log":   "x",
+		"src/dist/x.go":       "x",
+		"node_modules/m/a.go": "x",
+		"dist/app.go":         "x",
+		"..cache/c.go":        "x",
+		"build":               "a file, not a directory",
+		"debug.log":           "x",
+		"keep.log":            "x",
+		"local.txt":           "x",
+		"README.md":           "x",
+	}
+	for p, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0755)
+		os.WriteFile(filepath.Join(dir, p), []byte(content), 0644)
+	}
+	search := func(respect *bool) string {
+		res, err := tools.SearchFiles(tools.SearchFilesParams{Path: ".", Pattern: "*", RespectGitignore: respect}, []string{dir})
+		if err != nil || res["isError"] != nil {
+			t.Fatalf("SearchFiles: %v %v", res, err)
+		}
+		var got []string
+		for _, m := range res["matches"].([]map[string]interface{}) {
+			if m["type"] == "file" {
+				got = append(got, m["path"].(string))
+			}
+		}
+		sort.Strings(got)
+		return strings.Join(got, ",")
+	}
+	want := ".gitignore,README.md,build,keep.log,src/.gitignore,src/.mcpignore,src/dist/x.go,src/important.log,src/main.go"
+	if got := search(nil); got != want {
+		t.Errorf("SearchFiles ignoring = %q, want %q", got, want)
+	}
+	off := false
+	if got := search(&off); !strings.Contains(got, "node_modules/m/a.go") || !strings.Contains(got, ".git/HEAD") {
+		t.Errorf("SearchFiles with respectGitignore false = %q", got)
+	}
+
+	res, err := tools.DirectoryTree(tools.DirectoryTreeParams{Path: "src"}, []string{dir})
+	if err != nil {
+		t.Fatalf("DirectoryTree: %v", err)
+	}
+	var names []string
+	for _, c := range res["tree"].(tools.TreeEntry).Children {
+		names = append(names, c.Name)
+	}
+	if got := strings.Join(names, ","); got != ".gitignore,.mcpignore,dist,important.log,main.go" {
+		t.Errorf("DirectoryTree children = %q", got)
+	}
+
+	on := true
+	res, err = tools.ListDirectoryWithSizes(tools.ListDirectoryWithSizesParams{Path: ".", RespectGitignore: &on}, []string{dir})
+	if err != nil {
+		t.Fatalf("ListDirectoryWithSizes: %v", err)
+	}
+	names = nil
+	for _, e := range res["entries"].([]map[string]interface{}) {
+		names = append(names, e["name"].(string))
+	}
+	if got := strings.Join(names, ","); got != ".gitignore,README.md,build,keep.log,src" {
+		t.Errorf("ListDirectoryWithSizes entries = %q", got)
+	}
+
+	// grep_files and replace_in_files skip ignored files too.
+	res, err = tools.GrepFiles(tools.GrepFilesParams{Path: ".", Pattern: "x"}, []string{dir})
+	if err != nil {
+		t.Fatalf("GrepFiles: %v", err)
+	}
+	names = nil
+	for _, m := range res["matches"].([]map[string]interface{}) {
+		names = append(names, m["path"].(string))
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != "README.md,keep.log,src/dist/x.go,src/important.log,src/main.go" {
+		t.Errorf("GrepFiles matched %q", got)
+	}
+	res, err = tools.ReplaceInFiles(tools.ReplaceInFilesParams{Path: ".", Pattern: "x", Replacement: "y", DryRun: true, RespectGitignore: &off}, []string{dir})
+	if err != nil || res["filesChanged"] != 13 {
+		t.Errorf("ReplaceInFiles with respectGitignore false: %v %v", res["filesChanged"], err)
+	}
+
+	// An allowed directory inside a repository gets the repository's rules.
+	res, err = tools.FindFiles(tools.FindFilesParams{Path: "."}, []string{filepath.Join(dir, "src")})
+	if err != nil {
+		t.Fatalf("FindFiles: %v", err)
+	}
+	names = nil
+	for _, e := range res["entries"].([]map[string]interface{}) {
+		names = append(names, e["path"].(string))
+	}
+	sort.Strings(names)
+	if got := strings.Join(names, ","); got != ".gitignore,.mcpignore,dist,dist/x.go,important.log,main.go" {
+		t.Errorf("FindFiles below the repository root = %q", got)
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(p string, size int, mode os.FileMode, age time.Duration) {
+		full := filepath.Join(dir, p)
+		os.MkdirAll(filepath.Dir(full), 0755)
+		os.WriteFile(full, []byte(strings.Repeat("x", size)), mode)
+		os.Chmod(full, mode)
+		mtime := time.Now().Add(-age)
+		os.Chtimes(full, mtime, mtime)
+	}
+	write("run.sh", 100, 0755, time.Hour)
+	write("big.bin", 3<<20, 0644, 48*time.Hour)
+	write("notes/empty.txt", 0, 0600, time.Minute)
+	write("notes/old.txt", 10, 0644, 30*24*time.Hour)
+	write("src/deep/x/y.go", 2048, 0644, 3*time.Hour)
+	os.MkdirAll(filepath.Join(dir, "hollow"), 0755)
+	os.Symlink("run.sh", filepath.Join(dir, "link"))
+
+	find := func(params tools.FindFilesParams) string {
+		params.Path = "."
+		res, err := tools.FindFiles(params, []string{dir})
+		if err != nil {
+			t.Fatalf("FindFiles(%+v): %v", params, err)
+		}
+		var got []string
+		for _, e := range res["entries"].([]map[string]interface{}) {
+			got = append(got, e["path"].(string))
+		}
+		return strings.Join(got, ",")
+	}
+	cases := []struct {
+		params tools.FindFilesParams
+		want   string
+	}{
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "dir"}}, "hollow,notes,src,src/deep,src/deep/x"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "symlink"}}, "link"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{MinSize: "1M"}}, "big.bin"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{MinSize: "1k", MaxSize: "1M"}}, "src/deep/x/y.go"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "file", NewerThan: "2h"}}, "notes/empty.txt,run.sh"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "file", OlderThan: "7d"}}, "notes/old.txt"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "file", Perm: "-100"}}, "run.sh"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "file", Perm: "600"}}, "notes/empty.txt"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Empty: true}}, "hollow,notes/empty.txt"},
+		{tools.FindFilesParams{MaxDepth: 2, FindFilter: tools.FindFilter{Type: "dir"}}, "hollow,notes,src,src/deep"},
+		{tools.FindFilesParams{MinDepth: 3}, "src/deep/x,src/deep/x/y.go"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Name: []string{"*.txt"}, NewerThan: "1d"}}, "notes/empty.txt"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "file"}, AnyOf: []tools.FindFilter{{Name: []string{"*.go"}}, {MinSize: "1M"}}}, "big.bin,src/deep/x/y.go"},
+		{tools.FindFilesParams{FindFilter: tools.FindFilter{Type: "file"}, Exclude: []string{"src/", "notes/"}}, "big.bin,run.sh"},
+	}
+	for _, c := range cases {
+		if got := find(c.params); got != c.want {
+			t.Errorf("FindFiles(%+v) = %q, want %q", c.params, got, c.want)
+		}
+	}
+
+	res, _ := tools.FindFiles(tools.FindFilesParams{Path: ".", FindFilter: tools.FindFilter{Name: []string{"run.sh"}}}, []string{dir})
+	e := res["entries"].([]map[string]interface{})[0]
+	if e["type"] != "file" || e["size"] != int64(100) || e["mode"] != "0755" || e["mtime"] == "" {
+		t.Errorf("FindFiles entry = %v", e)
+	}
+	for _, bad := range []tools.FindFilesParams{{FindFilter: tools.FindFilter{MinSize: "lots"}}, {FindFilter: tools.FindFilter{NewerThan: "yesterday"}}, {FindFilter: tools.FindFilter{Perm: "999"}}, {AnyOf: []tools.FindFilter{{MaxSize: "x"}}}} {
+		bad.Path = "."
+		if _, err := tools.FindFiles(bad, []string{dir}); err == nil {
+			t.Errorf("FindFiles(%+v): expected an error", bad)
+		}
+	}
+
+	// Special bits are matched and reported as in chmod.
+	os.Chmod(filepath.Join(dir, "run.sh"), 0755|os.ModeSetuid)
+	os.Chmod(filepath.Join(dir, "hollow"), 0777|os.ModeSticky)
+	for _, c := range []struct{ perm, want string }{{"-4000", "run.sh"}, {"4755", "run.sh"}, {"755", "notes,src"}, {"/3000", "hollow"}, {"1777", "hollow"}} {
+		if got := find(tools.FindFilesParams{MaxDepth: 1, FindFilter: tools.FindFilter{Perm: c.perm}}); got != c.want {
+			t.Errorf("FindFiles(perm %s) = %q, want %q", c.perm, got, c.want)
+		}
+	}
+	res, _ = tools.FindFiles(tools.FindFilesParams{Path: ".", FindFilter: tools.FindFilter{Name: []string{"run.sh"}}}, []string{dir})
+	if e := res["entries"].([]map[string]interface{})[0]; e["mode"] != "4755" {
+		t.Errorf("FindFiles mode = %v, want 4755", e["mode"])
+	}
+}
@@ -0,0 +1,319 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Operation kinds accepted by the batch tool.
+const (
+	batchWrite  = "write"
+	batchEdit   = "edit"
+	batchMove   = "move"
+	batchDelete = "delete"
+	batchMkdir  = "mkdir"
+)
+
+// BatchOperation is one step of a batch. Which fields are used depends on Op:
+// write uses Path and Content, edit uses Path and Edits, move uses Source,
+// Destination and Overwrite, delete uses Path, Recursive and Permanent, and
+// mkdir uses Path.
+type BatchOperation struct {
+	Op          string          `json:"op" description:"Operation" required:"true" enum:"write,edit,move,delete,mkdir"`
+	Path        string          `json:"path" description:"Target of write, edit, delete and mkdir"`
+	Content     string          `json:"content" description:"Content for write"`
+	Edits       []EditOperation `json:"edits" description:"Edits for edit"`
+	Source      string          `json:"source" description:"Source for move"`
+	Destination string          `json:"destination" description:"Destination for move"`
+	Overwrite   bool            `json:"overwrite" description:"Replace an existing destination on move"`
+	Recursive   bool            `json:"recursive" description:"Allow deleting a non-empty directory"`
+	Permanent   bool            `json:"permanent" description:"Delete without using the trash"`
+}
+
+type BatchParams struct {
+	Operations []BatchOperation `json:"operations" description:"Operations to apply in order" required:"true"`
+}
+
+// validateBatchOperation checks that op is well formed and that every path it
+// touches lies within the allowed roots.
+func validateBatchOperation(op BatchOperation, allowedDirs []string) error {
+	var paths []string
+	switch op.Op {
+	case batchWrite, batchMkdir:
+		paths = []string{op.Path}
+	case batchEdit:
+		if len(op.Edits) == 0 {
+			return errors.New("edit requires at least one edit")
+		}
+		paths = []string{op.Path}
+	case batchDelete:
+		if op.Permanent && !CurrentPolicy().AllowPermanentDelete {
+			return errors.New("permanent delete is disabled by policy")
+		}
+		paths = []string{op.Path}
+	case batchMove:
+		paths = []string{op.Source, op.Destination}
+	default:
+		return fmt.Errorf("unknown op %q (expected write, edit, move, delete or mkdir)", op.Op)
+	}
+	// Moves and deletes act on links themselves rather than their targets.
+	follow := op.Op != batchMove && op.Op != batchDelete
+	for _, p := range paths {
+		if p == "" {
+			return fmt.Errorf("%s: missing path", op.Op)
+		}
+		if _, err := findAllowedPath(allowedDirs, p, follow); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchRun applies operations and keeps what is needed to undo each one.
+// Prior versions of overwritten files are stashed in a scratch directory
+// inside the trash of the affected root.
+type batchRun struct {
+	allowedDirs []string
+	id          string
+	stashes     map[string]string
+	undo        []func() error
+}
+
+func (b *batchRun) stashPath(absPath string, index int) (string, error) {
+	root, err := rootFor(b.allowedDirs, absPath)
+	if err != nil {
+		return "", err
+	}
+	dir, ok := b.stashes[root]
+	if !ok {
+		dir = filepath.Join(trashDir(root), "batch-"+b.id)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+		b.stashes[root] = dir
+	}
+	return filepath.Join(dir, strconv.Itoa(index)), nil
+}
+
+// backupFile copies an existing file aside and returns a function that puts
+// it back, or one that removes the file if it did not exist.
+func (b *batchRun) backupFile(absPath string, index int) (func() error, error) {
+	info, err := os.Lstat(absPath)
+	if os.IsNotExist(err) {
+		return func() error { return os.Remove(absPath) }, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", absPath)
+	}
+	stash, err := b.stashPath(absPath, index)
+	if err != nil {
+		return nil, err
+	}
+	var stats copyStats
+	if err := copyEntry(absPath, stash, info, overwriteReplace, false, &stats); err != nil {
+		return nil, err
+	}
+	return func() error {
+		_, err := moveEntry(stash, absPath, true)
+		return err
+	}, nil
+}
+
+func (b *batchRun) apply(index int, op BatchOperation) (ToolResult, error) {
+	switch op.Op {
+	case batchWrite:
+		absPath, _ := findAllowedRoot(b.allowedDirs, op.Path)
+		undo, err := b.backupFile(absPath, index)
+		if err != nil {
+			return nil, err
+		}
+		res, err := WriteFile(WriteFileParams{Path: op.Path, Content: op.Content}, b.allowedDirs)
+		if err != nil {
+			return nil, err
+		}
+		b.undo = append(b.undo, undo)
+		return res, nil
+
+	case batchEdit:
+		absPath, _ := findAllowedRoot(b.allowedDirs, op.Path)
+		undo, err := b.backupFile(absPath, index)
+		if err != nil {
+			return nil, err
+		}
+		res, err := EditFile(EditFileParams{Path: op.Path, Edits: op.Edits}, b.allowedDirs)
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := res["ok"].(bool); !ok {
+			return res, errors.New("edit made no changes")
+		}
+		b.undo = append(b.undo, undo)
+		return res, nil
+
+	case batchMkdir:
+		absPath, _ := findAllowedRoot(b.allowedDirs, op.Path)
+		// Remember the topmost directory that will be created.
+		created := ""
+		for p := absPath; ; p = filepath.Dir(p) {
+			if _, err := os.Lstat(p); err == nil {
+				break
+			}
+			created = p
+			if filepath.Dir(p) == p {
+				break
+			}
+		}
+		res, err := CreateDirectory(CreateDirectoryParams{Path: op.Path}, b.allowedDirs)
+		if err != nil {
+			return nil, err
+		}
+		b.undo = append(b.undo, func() error {
+			if created == "" {
+				return nil
+			}
+			return os.RemoveAll(created)
+		})
+		return res, nil
+
+	case batchMove:
+		src, _ := findAllowedPath(b.allowedDirs, op.Source, false)
+		dst, _ := findAllowedPath(b.allowedDirs, op.Destination, false)
+		stash := ""
+		if _, err := os.Lstat(dst); err == nil && op.Overwrite {
+			p, err := b.stashPath(dst, index)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := moveEntry(dst, p, false); err != nil {
+				return nil, err
+			}
+			stash = p
+		}
+		method, err := moveEntry(src, dst, op.Overwrite)
+		if err != nil {
+			if stash != "" {
+				moveEntry(stash, dst, false)
+			}
+			return nil, err
+		}
+		b.undo = append(b.undo, func() error {
+			if _, err := moveEntry(dst, src, false); err != nil {
+				return err
+			}
+			if stash != "" {
+				_, err := moveEntry(stash, dst, false)
+				return err
+			}
+			return nil
+		})
+		return ToolResult{"ok": true, "method": method}, nil
+
+	case batchDelete:
+		absPath, _ := findAllowedPath(b.allowedDirs, op.Path, false)
+		// A dry run applies the same existence, recursion and size checks.
+		check, err := DeleteFile(DeleteFileParams{Path: op.Path, Recursive: op.Recursive, DryRun: true}, b.allowedDirs)
+		if err != nil {
+			return nil, err
+		}
+		if refused, ok := check["refused"].(string); ok {
+			return nil, errors.New(refused)
+		}
+		if op.Permanent {
+			// Keep the entry aside until the whole batch has succeeded.
+			stash, err := b.stashPath(absPath, index)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := moveEntry(absPath, stash, false); err != nil {
+				return nil, err
+			}
+			b.undo = append(b.undo, func() error {
+				_, err := moveEntry(stash, absPath, false)
+				return err
+			})
+			return ToolResult{"ok": true, "permanent": true}, nil
+		}
+		res, err := DeleteFile(DeleteFileParams{Path: op.Path, Recursive: op.Recursive}, b.allowedDirs)
+		if err != nil {
+			return nil, err
+		}
+		trashID, _ := res["trashId"].(string)
+		b.undo = append(b.undo, func() error {
+			_, err := RestoreFromTrash(RestoreFromTrashParams{ID: trashID}, b.allowedDirs)
+			return err
+		})
+		return res, nil
+	}
+	return nil, fmt.Errorf("unknown op %q", op.Op)
+}
+
+// cleanup removes the scratch directories used for stashed files.
+func (b *batchRun) cleanup() {
+	for _, dir := range b.stashes {
+		os.RemoveAll(dir)
+	}
+}
+
+// Batch validates every operation against the allowed roots, then applies
+// them in order. If one fails, the operations already applied are undone in
+// reverse order. The report has one entry per operation.
+func Batch(params BatchParams, allowedDirs []string) (ToolResult, error) {
+	if len(params.Operations) == 0 {
+		return nil, errors.New("no operations given")
+	}
+	for i, op := range params.Operations {
+		if err := validateBatchOperation(op, allowedDirs); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %v", i, op.Op, err)
+		}
+	}
+	id, err := newTrashID()
+	if err != nil {
+		return nil, err
+	}
+	run := &batchRun{allowedDirs: allowedDirs, id: id, stashes: map[string]string{}}
+	defer run.cleanup()
+
+	report := make([]map[string]interface{}, len(params.Operations))
+	for i, op := range params.Operations {
+		report[i] = map[string]interface{}{"index": i, "op": op.Op, "status": "skipped"}
+	}
+	failed := -1
+	for i, op := range params.Operations {
+		res, err := run.apply(i, op)
+		if err != nil {
+			report[i]["status"] = "failed"
+			report[i]["error"] = err.Error()
+			failed = i
+			break
+		}
+		report[i]["status"] = "applied"
+		report[i]["result"] = res
+	}
+	if failed < 0 {
+		return ToolResult{"ok": true, "operations": report}, nil
+	}
+
+	rollbackOK := true
+	for i := len(run.undo) - 1; i >= 0; i-- {
+		if err := run.undo[i](); err != nil {
+			rollbackOK = false
+			report[i]["status"] = "rollbackFailed"
+			report[i]["error"] = err.Error()
+			continue
+		}
+		report[i]["status"] = "rolledBack"
+	}
+	return ToolResult{
+		"ok":         false,
+		"failedAt":   failed,
+		"rolledBack": rollbackOK,
+		"operations": report,
+		"isError":    true,
+	}, nil
+}
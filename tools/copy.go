@@ -0,0 +1,351 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Overwrite policies accepted by the copy tools.
+const (
+	overwriteFail    = "fail"
+	overwriteSkip    = "skip"
+	overwriteReplace = "overwrite"
+)
+
+func normalizeOverwrite(policy string) (string, error) {
+	switch policy {
+	case "", overwriteFail:
+		return overwriteFail, nil
+	case overwriteSkip, overwriteReplace:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid overwrite policy %q (expected fail, skip or overwrite)", policy)
+}
+
+// copyStats accumulates what a copy operation did.
+type copyStats struct {
+	Copied  int
+	Skipped int
+	Bytes   int64
+	Cloned  int
+}
+
+func (s copyStats) result() ToolResult {
+	return ToolResult{
+		"ok":      true,
+		"copied":  s.Copied,
+		"skipped": s.Skipped,
+		"bytes":   s.Bytes,
+		"cloned":  s.Cloned,
+	}
+}
+
+// tempSibling returns an unused name in dst's directory for staging a copy
+// that is renamed into place once complete.
+func tempSibling(dst string) (string, error) {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-"+hex.EncodeToString(b[:])), nil
+}
+
+// commitCopy renames the staged tmp onto dst, refusing to replace an
+// existing dst unless replace is set. tmp is removed if it cannot be placed.
+func commitCopy(tmp, dst string, replace bool) error {
+	var err error
+	if replace {
+		err = os.Rename(tmp, dst)
+	} else {
+		err = renameNoReplace(tmp, dst)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
+
+// copyRegularFile copies src to dst, preserving mode and modification time.
+// The data is written and synced to a temporary file next to dst, which is
+// then renamed into place, so dst is never left partially written. On Linux
+// it first tries a reflink clone and otherwise relies on io.Copy, which uses
+// copy_file_range when both ends are regular files.
+func copyRegularFile(src, dst string, info fs.FileInfo, replace, verify bool, stats *copyStats) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := tempSibling(dst)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	cloned, err := func() (bool, error) {
+		cloned := cloneFile(out, in) == nil
+		if !cloned {
+			if _, err := io.Copy(out, in); err != nil {
+				out.Close()
+				return false, err
+			}
+		}
+		if err := out.Sync(); err != nil {
+			out.Close()
+			return false, err
+		}
+		if err := out.Close(); err != nil {
+			return false, err
+		}
+		if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+			return false, err
+		}
+		if err := os.Chtimes(tmp, info.ModTime(), info.ModTime()); err != nil {
+			return false, err
+		}
+		if verify {
+			if err := verifyCopy(src, tmp); err != nil {
+				return false, err
+			}
+		}
+		return cloned, nil
+	}()
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := commitCopy(tmp, dst, replace); err != nil {
+		return err
+	}
+	stats.Copied++
+	stats.Bytes += info.Size()
+	if cloned {
+		stats.Cloned++
+	}
+	return nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+func verifyCopy(src, dst string) error {
+	want, err := fileSHA256(src)
+	if err != nil {
+		return err
+	}
+	got, err := fileSHA256(dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("checksum mismatch after copying %s", src)
+	}
+	return nil
+}
+
+// copyEntry copies a single non-directory entry, applying the overwrite policy
+// when dst already exists. The copy is staged and renamed into place, and
+// without overwriteReplace that rename never replaces an entry created in
+// the meantime.
+func copyEntry(src, dst string, info fs.FileInfo, overwrite string, verify bool, stats *copyStats) error {
+	if existing, err := os.Lstat(dst); err == nil {
+		switch overwrite {
+		case overwriteSkip:
+			stats.Skipped++
+			return nil
+		case overwriteFail:
+			return fmt.Errorf("destination already exists: %s", dst)
+		}
+		if existing.IsDir() {
+			return fmt.Errorf("cannot overwrite directory with file: %s", dst)
+		}
+	}
+	replace := overwrite == overwriteReplace
+	var err error
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		err = copySymlink(src, dst, replace, stats)
+	case info.Mode().IsRegular():
+		err = copyRegularFile(src, dst, info, replace, verify, stats)
+	default:
+		return fmt.Errorf("cannot copy special file: %s", src)
+	}
+	if errors.Is(err, errDestinationExists) {
+		if overwrite == overwriteSkip {
+			stats.Skipped++
+			return nil
+		}
+		return fmt.Errorf("destination already exists: %s", dst)
+	}
+	return err
+}
+
+func copySymlink(src, dst string, replace bool, stats *copyStats) error {
+	target, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	tmp, err := tempSibling(dst)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := commitCopy(tmp, dst, replace); err != nil {
+		return err
+	}
+	stats.Copied++
+	return nil
+}
+
+// copyTree copies the directory src to dst recursively. Directory modes and
+// modification times are applied after their contents have been written.
+func copyTree(src, dst, overwrite string, verify bool, stats *copyStats) error {
+	if dst == src || strings.HasPrefix(dst, src+string(os.PathSeparator)) {
+		return errors.New("cannot copy a directory into itself")
+	}
+	if overwrite == overwriteFail {
+		if _, err := os.Lstat(dst); err == nil {
+			return fmt.Errorf("destination already exists: %s", dst)
+		}
+	}
+	type dirTimes struct {
+		path string
+		info fs.FileInfo
+	}
+	var dirs []dirTimes
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != src && d.Name() == trashDirName {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if existing, err := os.Lstat(target); err == nil && !existing.IsDir() {
+				return fmt.Errorf("cannot overwrite file with directory: %s", target)
+			}
+			// Keep the directory writable until its contents are copied.
+			if err := os.MkdirAll(target, info.Mode().Perm()|0700); err != nil {
+				return err
+			}
+			dirs = append(dirs, dirTimes{target, info})
+			return nil
+		}
+		return copyEntry(path, target, info, overwrite, verify, stats)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(dirs[i].path, dirs[i].info.ModTime(), dirs[i].info.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type CopyFileParams struct {
+	Source      string `json:"source" description:"Source file" required:"true"`
+	Destination string `json:"destination" description:"Destination file" required:"true"`
+	Overwrite   string `json:"overwrite" description:"What to do if the destination exists" enum:"fail,skip,overwrite" default:"fail"`
+	Verify      bool   `json:"verify" description:"Verify the copy with a SHA-256 checksum"`
+}
+
+func CopyFile(params CopyFileParams, allowedDirs []string) (ToolResult, error) {
+	src, err := findAllowedRoot(allowedDirs, params.Source)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := findWritableRoot(allowedDirs, params.Destination)
+	if err != nil {
+		return nil, err
+	}
+	overwrite, err := normalizeOverwrite(params.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("source is a directory; use copy_directory")
+	}
+	if src == dst {
+		return nil, errors.New("source and destination are the same file")
+	}
+	var stats copyStats
+	if err := copyEntry(src, dst, info, overwrite, params.Verify, &stats); err != nil {
+		return nil, err
+	}
+	return stats.result(), nil
+}
+
+type CopyDirectoryParams struct {
+	Source      string `json:"source" description:"Source directory" required:"true"`
+	Destination string `json:"destination" description:"Destination directory" required:"true"`
+	Overwrite   string `json:"overwrite" description:"What to do if a destination entry exists" enum:"fail,skip,overwrite" default:"fail"`
+	Verify      bool   `json:"verify" description:"Verify every copied file with a SHA-256 checksum"`
+}
+
+func CopyDirectory(params CopyDirectoryParams, allowedDirs []string) (ToolResult, error) {
+	src, err := findAllowedRoot(allowedDirs, params.Source)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := findWritableRoot(allowedDirs, params.Destination)
+	if err != nil {
+		return nil, err
+	}
+	overwrite, err := normalizeOverwrite(params.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("source is not a directory; use copy_file")
+	}
+	var stats copyStats
+	if err := copyTree(src, dst, overwrite, params.Verify, &stats); err != nil {
+		return nil, err
+	}
+	return stats.result(), nil
+}
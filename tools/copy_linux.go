@@ -0,0 +1,15 @@
+//go:build linux
+
+package tools
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share src's data blocks with the FICLONE ioctl. It only
+// succeeds on filesystems with reflink support such as Btrfs or XFS.
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package tools
+
+import (
+	"errors"
+	"os"
+)
+
+func cloneFile(dst, src *os.File) error {
+	return errors.New("reflink is not supported on this platform")
+}
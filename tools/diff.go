@@ -0,0 +1,247 @@
+package tools
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// defaultContextLines is the number of unchanged lines shown around each
+// change in unified diffs, as in diff -u.
+const defaultContextLines = 3
+
+// diffTraceLimit caps the memory used by the Myers search, in ints. Inputs
+// needing more are diffed as one block replacing the other.
+const diffTraceLimit = 1 << 24
+
+// diffOp is one line of an edit script: ' ' keeps a line, '-' removes a line
+// of a and '+' inserts a line of b.
+type diffOp struct {
+	Kind byte
+	Text string
+}
+
+// splitLines splits text into lines that keep their "\n", so a last line
+// without a newline differs from the same line with one.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script turning a into b. Common leading
+// and trailing lines are trimmed before running Myers' O(ND) algorithm, whose
+// script keeps a longest common subsequence of the two.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	var ops []diffOp
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+func myers(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	if max == 0 {
+		return nil
+	}
+	offset := max + 1
+	v := make([]int, 2*max+2)
+	var trace [][]int
+	found := false
+	for d := 0; d <= max && !found; d++ {
+		if (len(trace)+1)*len(v) > diffTraceLimit {
+			return replaceAll(a, b)
+		}
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// Walk the trace backwards from (n, m) to recover the script.
+	var rev []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			rev = append(rev, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, diffOp{'+', b[y-1]})
+			} else {
+				rev = append(rev, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	ops := make([]diffOp, len(rev))
+	for i := range rev {
+		ops[i] = rev[len(rev)-1-i]
+	}
+	return ops
+}
+
+func replaceAll(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a {
+		ops = append(ops, diffOp{'-', l})
+	}
+	for _, l := range b {
+		ops = append(ops, diffOp{'+', l})
+	}
+	return ops
+}
+
+// hunkRange formats one side of a hunk header the way diff -u does: the
+// count is omitted when it is 1, and an empty range names the line before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// unifiedDiff returns the unified diff between two texts with the given
+// number of context lines, or "" if they are equal. An empty name is shown
+// as /dev/null, for created and deleted files.
+func unifiedDiff(oldName, newName, oldText, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+	if context < 0 {
+		context = 0
+	}
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	// oldLine[i] and newLine[i] are the 1-based line numbers at ops[i].
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	oldLine[0], newLine[0] = 1, 1
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.Kind != '+' {
+			oldLine[i+1]++
+		}
+		if op.Kind != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	var b strings.Builder
+	if oldName == "" {
+		oldName = "/dev/null"
+	}
+	if newName == "" {
+		newName = "/dev/null"
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for i := 0; i < len(ops); {
+		if ops[i].Kind == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk while the next change is within 2*context lines.
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].Kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:stop] {
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLine[start], oldCount), hunkRange(newLine[start], newCount))
+		for _, op := range ops[start:stop] {
+			b.WriteByte(op.Kind)
+			b.WriteString(op.Text)
+			if !strings.HasSuffix(op.Text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return b.String()
+}
+
+// contextLines returns the requested number of context lines, or the default
+// when none was given.
+func contextLines(n *int) int {
+	if n == nil {
+		return defaultContextLines
+	}
+	return *n
+}
+
+// diffNames returns the a/ and b/ header names for absPath, relative to its
+// allowed root as in git diffs.
+func diffNames(allowedDirs []string, absPath string) (string, string) {
+	name := filepath.Base(absPath)
+	if root, err := rootFor(allowedDirs, absPath); err == nil {
+		if rel, err := filepath.Rel(root, absPath); err == nil {
+			name = filepath.ToSlash(rel)
+		}
+	}
+	return "a/" + name, "b/" + name
+}
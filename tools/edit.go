@@ -0,0 +1,313 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EditOperation replaces OldText with NewText. Match selects how OldText is
+// compared with the file: "exact" (the default) or "whitespace", which also
+// accepts blocks that differ only in indentation and spacing.
+//
+// With Regex, OldText is a Go regular expression and NewText may refer to
+// its groups as $1 or ${name}. Multiline and CaseInsensitive set the m and i
+// flags, and MaxReplacements caps how many matches may be replaced.
+//
+// When Op is set the edit addresses lines instead: insert puts NewText after
+// Line (0 for the top), delete and replace act on StartLine..EndLine, and
+// insertBefore and insertAfter put NewText next to the line matching Pattern.
+type EditOperation struct {
+	OldText         string  `json:"oldText" description:"Text to replace; exact unless match or regex say otherwise, may span lines"`
+	NewText         string  `json:"newText" description:"Replacement or inserted text"`
+	ReplaceAll      bool    `json:"replaceAll" description:"Replace every occurrence"`
+	Occurrence      int     `json:"occurrence" description:"Replace only the Nth occurrence (1-based)"`
+	Match           string  `json:"match" description:"exact, or whitespace to also accept blocks that differ in indentation and spacing" enum:"exact,whitespace" default:"exact"`
+	MinConfidence   float64 `json:"minConfidence" description:"Lowest confidence accepted for whitespace matches" default:"0.8"`
+	Regex           bool    `json:"regex" description:"Treat oldText as a Go regular expression; newText may use $1 or ${name}"`
+	Multiline       bool    `json:"multiline" description:"Regex: ^ and $ match at line boundaries"`
+	CaseInsensitive bool    `json:"caseInsensitive" description:"Regex: ignore case"`
+	MaxReplacements int     `json:"maxReplacements" description:"Regex: fail if more matches would be replaced" default:"1000"`
+	Op              string  `json:"op" description:"Address lines instead of oldText; requires expectedHash" enum:"insert,delete,replace,insertBefore,insertAfter"`
+	Line            int     `json:"line" description:"insert: line to insert after (0 for the top)"`
+	StartLine       int     `json:"startLine" description:"delete/replace: first line"`
+	EndLine         int     `json:"endLine" description:"delete/replace: last line (defaults to startLine)"`
+	Pattern         string  `json:"pattern" description:"insertBefore/insertAfter: regular expression matching the anchor line"`
+}
+
+type EditFileParams struct {
+	Path         string          `json:"path" description:"File to edit" required:"true"`
+	Edits        []EditOperation `json:"edits" description:"Edits to apply in order" required:"true"`
+	DryRun       bool            `json:"dryRun" description:"Preview changes without applying"`
+	ContextLines *int            `json:"contextLines" description:"Unchanged lines shown around each change in the diff" default:"3"`
+	ExpectedHash string          `json:"expectedHash" description:"Hash from read_file; the edit is refused if the file has changed since"`
+}
+
+// editHunk is a changed block of whole lines. Line numbers are 1-based and
+// refer to the text as it was just before and just after the edit.
+type editHunk struct {
+	OldStart int
+	OldLines []string
+	NewStart int
+	NewLines []string
+}
+
+// findOccurrences returns the byte offsets of non-overlapping matches of old.
+func findOccurrences(content, old string) []int {
+	var offsets []int
+	for i := 0; i <= len(content); {
+		j := strings.Index(content[i:], old)
+		if j < 0 {
+			break
+		}
+		offsets = append(offsets, i+j)
+		i += j + len(old)
+	}
+	return offsets
+}
+
+func lineAt(content string, offset int) int {
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+// lineBounds widens [start, end) to the whole lines it touches. A match that
+// ends with a newline ends its line there.
+func lineBounds(content string, start, end int) (int, int) {
+	ls := strings.LastIndex(content[:start], "\n") + 1
+	if end > start && content[end-1] == '\n' {
+		return ls, end
+	}
+	if i := strings.Index(content[end:], "\n"); i >= 0 {
+		return ls, end + i
+	}
+	return ls, len(content)
+}
+
+// textMatch is a span of content to replace and the text that replaces it.
+type textMatch struct {
+	Start, End int
+	Text       string
+}
+
+// replaceAt applies matches, which must be sorted and non-overlapping, and
+// returns the new content with one hunk per group of touched lines.
+func replaceAt(content string, matches []textMatch) (string, []editHunk) {
+	type span struct{ ls, le int }
+	var groups [][]textMatch
+	var spans []span
+	for _, m := range matches {
+		ls, le := lineBounds(content, m.Start, m.End)
+		if k := len(spans) - 1; k >= 0 && ls < spans[k].le {
+			spans[k].le = le
+			groups[k] = append(groups[k], m)
+			continue
+		}
+		spans = append(spans, span{ls, le})
+		groups = append(groups, []textMatch{m})
+	}
+	var out strings.Builder
+	var hunks []editHunk
+	prev := 0
+	lineDelta := 0
+	for k, sp := range spans {
+		out.WriteString(content[prev:sp.ls])
+		var block strings.Builder
+		cur := sp.ls
+		for _, m := range groups[k] {
+			block.WriteString(content[cur:m.Start])
+			block.WriteString(m.Text)
+			cur = m.End
+		}
+		block.WriteString(content[cur:sp.le])
+		oldBlock := content[sp.ls:sp.le]
+		newBlock := block.String()
+		out.WriteString(newBlock)
+		prev = sp.le
+
+		oldStart := lineAt(content, sp.ls)
+		h := editHunk{
+			OldStart: oldStart,
+			OldLines: splitLines(oldBlock),
+			NewStart: oldStart + lineDelta,
+			NewLines: splitLines(newBlock),
+		}
+		lineDelta += len(h.NewLines) - len(h.OldLines)
+		hunks = append(hunks, h)
+	}
+	out.WriteString(content[prev:])
+	return out.String(), hunks
+}
+
+// selectMatches narrows candidates according to the edit's occurrence and
+// replaceAll settings. Ambiguous matches are an error.
+func selectMatches(content string, candidates []textMatch, edit EditOperation) ([]textMatch, error) {
+	switch {
+	case edit.Occurrence < 0 || edit.Occurrence > len(candidates):
+		return nil, fmt.Errorf("occurrence %d out of range: oldText matches %d times", edit.Occurrence, len(candidates))
+	case edit.Occurrence > 0:
+		return candidates[edit.Occurrence-1 : edit.Occurrence], nil
+	case len(candidates) > 1 && !edit.ReplaceAll:
+		lines := make([]string, len(candidates))
+		for i, m := range candidates {
+			lines[i] = fmt.Sprint(lineAt(content, m.Start))
+		}
+		return nil, fmt.Errorf("oldText matches %d times (lines %s); set replaceAll or occurrence", len(candidates), strings.Join(lines, ", "))
+	}
+	return candidates, nil
+}
+
+// applyEdit applies one edit to normalized content. Matching is exact over
+// the whole content, so OldText may span several lines. In whitespace mode an
+// edit without an exact match falls back to fuzzyMatches.
+func applyEdit(content string, edit EditOperation) (string, []editHunk, matchInfo, error) {
+	info := matchInfo{Normalization: "none", Confidence: 1}
+	old := normalizeText([]byte(edit.OldText))
+	newText := normalizeText([]byte(edit.NewText))
+	if old == "" {
+		return "", nil, info, errors.New("oldText must not be empty")
+	}
+	switch edit.Match {
+	case "", matchExact, matchWhitespace:
+	default:
+		return "", nil, info, fmt.Errorf("invalid match %q (expected exact or whitespace)", edit.Match)
+	}
+	if edit.Regex {
+		if edit.Match == matchWhitespace {
+			return "", nil, info, errors.New("regex edits cannot use whitespace matching")
+		}
+		return applyRegexEdit(content, edit)
+	}
+	var candidates []textMatch
+	for _, off := range findOccurrences(content, old) {
+		candidates = append(candidates, textMatch{Start: off, End: off + len(old), Text: newText})
+	}
+	if len(candidates) == 0 && edit.Match == matchWhitespace {
+		var err error
+		candidates, info, err = fuzzyMatches(content, old, newText, edit.MinConfidence)
+		if err != nil {
+			return "", nil, info, err
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil, info, fmt.Errorf("oldText not found: %q", edit.OldText)
+	}
+	matches, err := selectMatches(content, candidates, edit)
+	if err != nil {
+		return "", nil, info, err
+	}
+	info.Replacements = len(matches)
+	updated, hunks := replaceAt(content, matches)
+	return updated, hunks, info, nil
+}
+
+// EditFile applies edits in order to a text file. Each edit sees the result of
+// the previous ones, and the line numbers it reports refer to that text. Line
+// numbers given to line-addressed edits refer to the file as read, which is
+// why they require ExpectedHash. Any failing edit aborts the whole call
+// without writing.
+func EditFile(params EditFileParams, allowedDirs []string) (ToolResult, error) {
+	absPath, err := findWritableRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	origData, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	for i, edit := range params.Edits {
+		if edit.Op != "" && params.ExpectedHash == "" {
+			return nil, fmt.Errorf("edit %d: %s requires expectedHash from read_file", i, edit.Op)
+		}
+	}
+	if params.ExpectedHash != "" {
+		if err := checkHash(origData, params.ExpectedHash); err != nil {
+			return nil, err
+		}
+	}
+	// Edits work on LF text without a BOM; the original style is restored on write.
+	text, style := decodeText(origData)
+	content := text
+	var report []map[string]interface{}
+	var history [][]editHunk
+	for i, edit := range params.Edits {
+		var updated string
+		var hunks []editHunk
+		info := matchInfo{Normalization: "none", Confidence: 1}
+		if edit.Op != "" {
+			updated, hunks, err = applyLineEdit(content, edit, history)
+			info.Replacements = len(hunks)
+		} else {
+			updated, hunks, info, err = applyEdit(content, edit)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("edit %d: %v", i, err)
+		}
+		history = append(history, hunks)
+		var lines []map[string]int
+		for _, h := range hunks {
+			lines = append(lines, map[string]int{
+				"oldStart": h.OldStart,
+				"oldEnd":   h.OldStart + len(h.OldLines) - 1,
+				"newStart": h.NewStart,
+				"newEnd":   h.NewStart + len(h.NewLines) - 1,
+			})
+		}
+		report = append(report, map[string]interface{}{
+			"index":         i,
+			"replacements":  info.Replacements,
+			"lines":         lines,
+			"normalization": info.Normalization,
+			"confidence":    info.Confidence,
+			"reindented":    info.Reindented,
+		})
+		if info.Matches != nil {
+			report[len(report)-1]["matches"] = info.Matches
+		}
+		content = updated
+	}
+	out := encodeText(withFinalNewline(content, style.FinalNewline), style)
+	out, formatted, formatErr := formatOnWrite(absPath, out)
+	if formatted {
+		content = normalizeText(out)
+	}
+	changed := content != text
+
+	oldName, newName := diffNames(allowedDirs, absPath)
+	result := ToolResult{
+		"diff":    unifiedDiff(oldName, newName, text, content, contextLines(params.ContextLines)),
+		"changed": changed,
+		"edits":   report,
+	}
+	addFormatResult(result, formatted, formatErr)
+
+	if params.DryRun {
+		result["preview"] = content
+		if serr := checkSyntax(absPath, out); serr != nil {
+			result["syntaxError"] = serr.toMap()
+		}
+		return result, nil
+	}
+
+	if changed {
+		warning, err := syntaxGate(absPath, out)
+		if err != nil {
+			return nil, err
+		}
+		if warning != nil {
+			result["syntaxWarning"] = warning
+		}
+		info, err := os.Stat(absPath)
+		if err != nil {
+			return nil, err
+		}
+		err = os.WriteFile(absPath, out, info.Mode().Perm())
+		if err != nil {
+			return nil, err
+		}
+		result["ok"] = true
+	} else {
+		result["ok"] = false
+	}
+	return result, nil
+}
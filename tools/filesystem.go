@@ -1,11 +1,17 @@
 package tools
 
 import (
-	"bytes"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 )
@@ -13,10 +19,117 @@ import (
 type ToolResult map[string]interface{}
 
 type ListDirectoryParams struct {
-	Path string `json:"path"`
+	Path string `json:"path" description:"Directory path" required:"true"`
 }
 
 func findAllowedRoot(allowedDirs []string, rel string) (string, error) {
+	return findAllowedPath(allowedDirs, rel, true)
+}
+
+// findAllowedPath resolves rel against the allowed roots. The returned path is
+// lexical; symbolic links are only evaluated to check that the real location
+// stays inside a root. Without follow, a link in the last element is not
+// followed, for tools that act on the link itself.
+func findAllowedPath(allowedDirs []string, rel string, follow bool) (string, error) {
+	absPath, err := lexicalAllowedPath(allowedDirs, rel)
+	if err != nil {
+		return "", err
+	}
+	var real string
+	if follow {
+		real, err = resolveReal(absPath, 0)
+	} else {
+		real, err = resolveReal(filepath.Dir(absPath), 0)
+		real = filepath.Join(real, filepath.Base(absPath))
+	}
+	if err != nil {
+		return "", err
+	}
+	if !withinRealRoots(allowedDirs, real) {
+		return "", errors.New("access outside of allowed directories is not allowed")
+	}
+	for _, p := range []string{absPath, real} {
+		if root, err := rootFor(allowedDirs, p); err == nil && isInTrash(root, p) {
+			return "", errTrashAccess
+		}
+	}
+	return absPath, nil
+}
+
+// findWritableRoot is findWritablePath following links, as findAllowedRoot
+// is for findAllowedPath.
+func findWritableRoot(allowedDirs []string, rel string) (string, error) {
+	return findWritablePath(allowedDirs, rel, true)
+}
+
+// findWritablePath resolves rel like findAllowedPath for tools that change
+// the entry, refusing it when it lies in a read-only root or resolves into
+// one.
+func findWritablePath(allowedDirs []string, rel string, follow bool) (string, error) {
+	absPath, err := findAllowedPath(allowedDirs, rel, follow)
+	if err != nil {
+		return "", err
+	}
+	if err := checkWritable(allowedDirs, absPath, follow); err != nil {
+		return "", err
+	}
+	return absPath, nil
+}
+
+// checkWritable refuses absPath, a path already checked by findAllowedPath,
+// when its root or the root its real location falls in is read-only.
+// Nested roots are judged by the most specific one.
+func checkWritable(allowedDirs []string, absPath string, follow bool) error {
+	readOnly := CurrentPolicy().ReadOnlyRoots
+	if len(readOnly) == 0 {
+		return nil
+	}
+	var real string
+	var err error
+	if follow {
+		real, err = resolveReal(absPath, 0)
+	} else {
+		real, err = resolveReal(filepath.Dir(absPath), 0)
+		real = filepath.Join(real, filepath.Base(absPath))
+	}
+	if err != nil {
+		return err
+	}
+	roots := []string{realRootFor(allowedDirs, real)}
+	if root, err := rootFor(allowedDirs, absPath); err == nil {
+		roots = append(roots, root)
+	}
+	for _, root := range roots {
+		for _, r := range readOnly {
+			if absRoot, err := filepath.Abs(filepath.Clean(r)); err == nil && root == absRoot {
+				return fmt.Errorf("%s is in a read-only directory", absPath)
+			}
+		}
+	}
+	return nil
+}
+
+// realRootFor returns the allowed root whose real location contains real,
+// preferring the most specific one, or "" if there is none.
+func realRootFor(allowedDirs []string, real string) string {
+	best, bestReal := "", ""
+	for _, root := range allowedDirs {
+		absRoot, err := filepath.Abs(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		realRoot, err := resolveReal(absRoot, 0)
+		if err != nil {
+			continue
+		}
+		if (real == realRoot || strings.HasPrefix(real, realRoot+string(os.PathSeparator))) && len(realRoot) > len(bestReal) {
+			best, bestReal = absRoot, realRoot
+		}
+	}
+	return best
+}
+
+func lexicalAllowedPath(allowedDirs []string, rel string) (string, error) {
 	cleanRel := filepath.Clean(rel)
 	if filepath.IsAbs(cleanRel) {
 		absRel := cleanRel
@@ -48,6 +161,9 @@ func ListDirectory(params ListDirectoryParams, allowedDirs []string) (ToolResult
 	}
 	var result []map[string]string
 	for _, entry := range entries {
+		if isTrashDir(allowedDirs, filepath.Join(absPath, entry.Name())) {
+			continue
+		}
 		typeStr := "file"
 		if entry.IsDir() {
 			typeStr = "directory"
@@ -61,44 +177,168 @@ func ListDirectory(params ListDirectoryParams, allowedDirs []string) (ToolResult
 }
 
 type ReadFileParams struct {
-	Path string `json:"path"`
+	Path      string `json:"path" description:"File path" required:"true"`
+	StartLine int    `json:"startLine" description:"First line to return (1-based)"`
+	EndLine   int    `json:"endLine" description:"Last line to return (inclusive)"`
+	Head      int    `json:"head" description:"Return only the first N lines"`
+	Tail      int    `json:"tail" description:"Return only the last N lines"`
 }
 
+// ReadFile returns a file's content and hash. With StartLine/EndLine, Head or
+// Tail only those lines are returned; the file is streamed, so reading a
+// range of a huge file stays cheap. The hash always covers the whole file.
 func ReadFile(params ReadFileParams, allowedDirs []string) (ToolResult, error) {
 	absPath, err := findAllowedRoot(allowedDirs, params.Path)
 	if err != nil {
 		return nil, err
 	}
-	data, err := os.ReadFile(absPath)
+	ranged := params.StartLine != 0 || params.EndLine != 0
+	if params.Head < 0 || params.Tail < 0 || params.StartLine < 0 || params.EndLine < 0 {
+		return nil, errors.New("line numbers and counts must not be negative")
+	}
+	if (ranged && (params.Head > 0 || params.Tail > 0)) || (params.Head > 0 && params.Tail > 0) {
+		return nil, errors.New("use only one of startLine/endLine, head and tail")
+	}
+	if !ranged && params.Head == 0 && params.Tail == 0 {
+		data, err := os.ReadFile(absPath)
+		if err != nil {
+			return nil, err
+		}
+		return ToolResult{"content": string(data), "hash": contentHash(data)}, nil
+	}
+	if params.EndLine != 0 && params.EndLine < params.StartLine {
+		return nil, fmt.Errorf("endLine %d is before startLine %d", params.EndLine, params.StartLine)
+	}
+
+	f, err := os.Open(absPath)
 	if err != nil {
 		return nil, err
 	}
-	return ToolResult{"content": string(data)}, nil
+	defer f.Close()
+	h := sha256.New()
+	r := bufio.NewReader(io.TeeReader(f, h))
+	start, end := params.StartLine, params.EndLine
+	if start == 0 {
+		start = 1
+	}
+	if params.Head > 0 {
+		end = params.Head
+	}
+	var kept []string
+	total := 0
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			total++
+			switch {
+			case params.Tail > 0:
+				kept = append(kept, line)
+				if len(kept) > params.Tail {
+					kept = kept[1:]
+				}
+			case total >= start && (end == 0 || total <= end):
+				kept = append(kept, line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if params.Tail > 0 {
+		start = total - len(kept) + 1
+	}
+	return ToolResult{
+		"content":    strings.Join(kept, ""),
+		"hash":       hex.EncodeToString(h.Sum(nil)),
+		"startLine":  start,
+		"endLine":    start + len(kept) - 1,
+		"totalLines": total,
+	}, nil
 }
 
 type WriteFileParams struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
+	Path                string `json:"path" description:"File path" required:"true"`
+	Content             string `json:"content" description:"File content" required:"true"`
+	DryRun              bool   `json:"dryRun" description:"Return a diff instead of writing"`
+	ContextLines        *int   `json:"contextLines" description:"Unchanged lines shown around each change in the diff" default:"3"`
+	PreserveLineEndings *bool  `json:"preserveLineEndings" description:"Convert the content to the existing file's line endings and byte order mark; false writes it as given" default:"true"`
 }
 
 func WriteFile(params WriteFileParams, allowedDirs []string) (ToolResult, error) {
-	absPath, err := findAllowedRoot(allowedDirs, params.Path)
+	absPath, err := findWritableRoot(allowedDirs, params.Path)
 	if err != nil {
 		return nil, err
 	}
-	err = os.WriteFile(absPath, []byte(params.Content), 0644)
+	data := []byte(params.Content)
+	perm := os.FileMode(0644)
+	exists := false
+	var existing []byte
+	// Existing text files keep their line endings and byte order mark
+	// unless the caller opts out. Binary content is written as given.
+	if info, statErr := os.Stat(absPath); statErr == nil && info.Mode().IsRegular() {
+		exists = true
+		perm = info.Mode().Perm()
+		existing, err = os.ReadFile(absPath)
+		preserve := params.PreserveLineEndings == nil || *params.PreserveLineEndings
+		if err == nil && len(existing) > 0 && preserve && !isBinary(existing) && !isBinary(data) {
+			data = encodeText(normalizeText(data), detectTextStyle(existing))
+		}
+	}
+	data, formatted, formatErr := formatOnWrite(absPath, data)
+	if params.DryRun {
+		// The diff compares normalized text, so a dry run over a CRLF file
+		// shows content changes rather than every line ending.
+		oldName, newName := diffNames(allowedDirs, absPath)
+		if !exists {
+			oldName = ""
+		}
+		result := ToolResult{
+			"ok":      true,
+			"dryRun":  true,
+			"exists":  exists,
+			"changed": !exists || string(existing) != string(data),
+			"diff":    unifiedDiff(oldName, newName, normalizeText(existing), normalizeText(data), contextLines(params.ContextLines)),
+		}
+		if serr := checkSyntax(absPath, data); serr != nil {
+			result["syntaxError"] = serr.toMap()
+		}
+		addFormatResult(result, formatted, formatErr)
+		return result, nil
+	}
+	result, err := writeChecked(absPath, data, perm)
 	if err != nil {
 		return nil, err
 	}
-	return ToolResult{"ok": true}, nil
+	addFormatResult(result, formatted, formatErr)
+	return result, nil
+}
+
+// writeChecked writes data to absPath after the syntax gate, reporting a
+// syntax warning when the policy only warns.
+func writeChecked(absPath string, data []byte, perm os.FileMode) (ToolResult, error) {
+	warning, err := syntaxGate(absPath, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(absPath, data, perm); err != nil {
+		return nil, err
+	}
+	result := ToolResult{"ok": true}
+	if warning != nil {
+		result["syntaxWarning"] = warning
+	}
+	return result, nil
 }
 
 type CreateDirectoryParams struct {
-	Path string `json:"path"`
+	Path string `json:"path" description:"Directory path" required:"true"`
 }
 
 func CreateDirectory(params CreateDirectoryParams, allowedDirs []string) (ToolResult, error) {
-	absPath, err := findAllowedRoot(allowedDirs, params.Path)
+	absPath, err := findWritableRoot(allowedDirs, params.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -110,15 +350,15 @@ func CreateDirectory(params CreateDirectoryParams, allowedDirs []string) (ToolRe
 }
 
 type GetFileInfoParams struct {
-	Path string `json:"path"`
+	Path string `json:"path" description:"Path" required:"true"`
 }
 
 func GetFileInfo(params GetFileInfoParams, allowedDirs []string) (ToolResult, error) {
-	absPath, err := findAllowedRoot(allowedDirs, params.Path)
+	absPath, err := findAllowedPath(allowedDirs, params.Path, false)
 	if err != nil {
 		return nil, err
 	}
-	info, err := os.Stat(absPath)
+	info, err := os.Lstat(absPath)
 	if err != nil {
 		return nil, err
 	}
@@ -127,117 +367,322 @@ func GetFileInfo(params GetFileInfoParams, allowedDirs []string) (ToolResult, er
 	creationTime = info.ModTime().Format("2006-01-02T15:04:05Z07:00")
 	accessTime = info.ModTime().Format("2006-01-02T15:04:05Z07:00")
 	perms := info.Mode().Perm().String()
-	return ToolResult{
+	result := ToolResult{
 		"size":         info.Size(),
 		"mode":         info.Mode().String(),
 		"modTime":      info.ModTime().Format("2006-01-02T15:04:05Z07:00"),
 		"isDir":        info.IsDir(),
+		"isSymlink":    info.Mode()&os.ModeSymlink != 0,
 		"name":         info.Name(),
 		"creationTime": creationTime,
 		"accessTime":   accessTime,
 		"permissions":  perms,
-	}, nil
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(absPath); err == nil {
+			result["linkTarget"] = target
+		}
+	}
+	return result, nil
 }
 
-type MoveFileParams struct {
-	Source      string `json:"source"`
-	Destination string `json:"destination"`
+type DeleteFileParams struct {
+	Path      string `json:"path" description:"Path to delete" required:"true"`
+	Permanent bool   `json:"permanent" description:"Bypass the trash and delete irreversibly"`
+	Recursive bool   `json:"recursive" description:"Required to delete a non-empty directory"`
+	DryRun    bool   `json:"dryRun" description:"List what would be removed without deleting"`
 }
 
-func MoveFile(params MoveFileParams, allowedDirs []string) (ToolResult, error) {
-	src, err := findAllowedRoot(allowedDirs, params.Source)
-	if err != nil {
-		return nil, err
+// deleteManifest lists what removing a path would take with it.
+type deleteManifest struct {
+	Files      []string
+	Entries    int
+	TotalBytes int64
+}
+
+// manifestPreviewLimit bounds how many paths a refusal message spells out.
+const manifestPreviewLimit = 20
+
+func buildDeleteManifest(root, absPath string) (deleteManifest, error) {
+	var m deleteManifest
+	err := filepath.WalkDir(absPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		m.Entries++
+		if d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		m.Files = append(m.Files, rel)
+		if info, err := d.Info(); err == nil && d.Type().IsRegular() {
+			m.TotalBytes += info.Size()
+		}
+		return nil
+	})
+	return m, err
+}
+
+func (m deleteManifest) summary() string {
+	preview := m.Files
+	more := ""
+	if len(preview) > manifestPreviewLimit {
+		more = fmt.Sprintf(", ... and %d more", len(preview)-manifestPreviewLimit)
+		preview = preview[:manifestPreviewLimit]
 	}
-	dst, err := findAllowedRoot(allowedDirs, params.Destination)
+	files := "no files"
+	if len(preview) > 0 {
+		files = strings.Join(preview, ", ") + more
+	}
+	return fmt.Sprintf("%d entries, %d files, %d bytes: %s", m.Entries, len(m.Files), m.TotalBytes, files)
+}
+
+func DeleteFile(params DeleteFileParams, allowedDirs []string) (ToolResult, error) {
+	absPath, err := findWritablePath(allowedDirs, params.Path, false)
 	if err != nil {
 		return nil, err
 	}
-	if _, err := os.Stat(dst); err == nil {
-		return nil, errors.New("destination already exists")
+	info, statErr := os.Lstat(absPath)
+	if os.IsNotExist(statErr) {
+		return nil, errors.New("file does not exist")
 	}
-	err = os.Rename(src, dst)
+	root, err := rootFor(allowedDirs, absPath)
 	if err != nil {
 		return nil, err
 	}
-	return ToolResult{"ok": true}, nil
-}
-
-type DeleteFileParams struct {
-	Path string `json:"path"`
-}
-
-func DeleteFile(params DeleteFileParams, allowedDirs []string) (ToolResult, error) {
-	absPath, err := findAllowedRoot(allowedDirs, params.Path)
+	if absPath == root {
+		return nil, errors.New("cannot delete an allowed root directory")
+	}
+	manifest, err := buildDeleteManifest(root, absPath)
 	if err != nil {
 		return nil, err
 	}
-	if _, statErr := os.Stat(absPath); os.IsNotExist(statErr) {
-		return nil, errors.New("file does not exist")
+	var refusal error
+	if info != nil && info.IsDir() && manifest.Entries > 1 && !params.Recursive {
+		refusal = fmt.Errorf("directory is not empty, set recursive: true to delete it; it would remove %s", manifest.summary())
+	} else if limit := CurrentPolicy().MaxDeleteEntries; limit > 0 && manifest.Entries > limit {
+		refusal = fmt.Errorf("delete exceeds the limit of %d entries per call; it would remove %s", limit, manifest.summary())
+	}
+	if params.DryRun {
+		files := manifest.Files
+		if files == nil {
+			files = []string{}
+		}
+		result := ToolResult{
+			"dryRun":     true,
+			"files":      files,
+			"entries":    manifest.Entries,
+			"totalBytes": manifest.TotalBytes,
+		}
+		if refusal != nil {
+			result["refused"] = refusal.Error()
+		}
+		return result, nil
+	}
+	if refusal != nil {
+		return nil, refusal
+	}
+	if params.Permanent {
+		if !CurrentPolicy().AllowPermanentDelete {
+			return nil, errors.New("permanent delete is disabled by policy")
+		}
+		err = os.RemoveAll(absPath)
+		if err != nil {
+			return nil, err
+		}
+		return ToolResult{"ok": true, "permanent": true}, nil
 	}
-	err = os.RemoveAll(absPath)
+	entry, err := moveToTrash(root, absPath)
 	if err != nil {
 		return nil, err
 	}
-	return ToolResult{"ok": true}, nil
+	return ToolResult{"ok": true, "trashId": entry.ID}, nil
 }
 
 type SearchFilesParams struct {
-	Path            string   `json:"path"`
-	Pattern         string   `json:"pattern"`
-	ExcludePatterns []string `json:"excludePatterns"`
+	Path             string   `json:"path" description:"Start directory" required:"true"`
+	Pattern          string   `json:"pattern" description:"Name pattern; matched against the path instead when it contains a slash" required:"true"`
+	MatchMode        string   `json:"matchMode" description:"How pattern is matched: glob (supports ** and {a,b}), substring, regex or fuzzy" enum:"glob,substring,regex,fuzzy" default:"glob"`
+	CaseSensitive    bool     `json:"caseSensitive" description:"Match pattern case-sensitively"`
+	ExcludePatterns  []string `json:"excludePatterns" description:"Globs of paths to skip; excluded directories are not entered"`
+	RespectGitignore *bool    `json:"respectGitignore" description:"Skip paths ignored by .gitignore, .git/info/exclude and .mcpignore files, and .git directories" default:"true"`
 }
 
+// fuzzyScore reports whether the characters of pattern appear in order in
+// s, and how many characters of s lie between the first and last of them.
+// Lower scores are tighter matches.
+func fuzzyScore(pattern, s string) (int, bool) {
+	p := []rune(pattern)
+	if len(p) == 0 {
+		return 0, true
+	}
+	first, i := -1, 0
+	for pos, r := range []rune(s) {
+		if r != p[i] {
+			continue
+		}
+		if first < 0 {
+			first = pos
+		}
+		i++
+		if i == len(p) {
+			return pos + 1 - first - len(p), true
+		}
+	}
+	return 0, false
+}
+
+// nameMatcher reports whether an entry matches a search_files pattern and,
+// for fuzzy matches, its score.
+type nameMatcher func(rel string) (int, bool)
+
+// compileNameMatcher builds the matcher for the substring, regex and fuzzy
+// modes. Patterns without a slash are matched against the entry name, others
+// against the path relative to the start directory.
+func compileNameMatcher(params SearchFilesParams) (nameMatcher, error) {
+	pattern := params.Pattern
+	if !params.CaseSensitive {
+		pattern = strings.ToLower(pattern)
+	}
+	subject := func(rel string) string {
+		if !strings.Contains(params.Pattern, "/") {
+			rel = path.Base(rel)
+		}
+		if !params.CaseSensitive {
+			rel = strings.ToLower(rel)
+		}
+		return rel
+	}
+	switch params.MatchMode {
+	case "substring":
+		return func(rel string) (int, bool) {
+			return 0, strings.Contains(subject(rel), pattern)
+		}, nil
+	case "regex":
+		expr := params.Pattern
+		if !params.CaseSensitive {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return func(rel string) (int, bool) {
+			if !strings.Contains(params.Pattern, "/") {
+				rel = path.Base(rel)
+			}
+			return 0, re.MatchString(rel)
+		}, nil
+	case "fuzzy":
+		return func(rel string) (int, bool) {
+			return fuzzyScore(pattern, subject(rel))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown match mode %q", params.MatchMode)
+}
+
+// nameRank orders search results: an exact name match first, then names
+// starting with the pattern, then names containing it, then the rest.
+func nameRank(pattern, rel string, caseSensitive bool) int {
+	name := path.Base(rel)
+	if !caseSensitive {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+	switch {
+	case name == pattern:
+		return 0
+	case strings.HasPrefix(name, pattern):
+		return 1
+	case strings.Contains(name, pattern):
+		return 2
+	}
+	return 3
+}
+
+func entryType(d fs.DirEntry) string {
+	switch {
+	case d.Type()&fs.ModeSymlink != 0:
+		return "symlink"
+	case d.IsDir():
+		return "dir"
+	}
+	return "file"
+}
+
+// SearchFiles finds entries below a directory whose name, or path when the
+// pattern contains a slash, matches a pattern. Matching ignores case unless
+// caseSensitive is set. Results are ranked by nameRank, fuzzy matches by
+// score within their rank, and otherwise kept in walk order. Excluded and,
+// by default, gitignored directories are not entered.
 func SearchFiles(params SearchFilesParams, allowedDirs []string) (ToolResult, error) {
+	errorResult := func(err error) (ToolResult, error) {
+		return ToolResult{"content": []map[string]interface{}{{"type": "text", "text": "Error: " + err.Error()}}, "isError": true}, nil
+	}
 	startDir, err := findAllowedRoot(allowedDirs, params.Path)
 	if err != nil {
-		return ToolResult{"content": []map[string]interface{}{{"type": "text", "text": "Error: " + err.Error()}}, "isError": true}, nil
+		return errorResult(err)
 	}
-	var matches []string
-	excludes := params.ExcludePatterns
-	isExcluded := func(rel string) bool {
-		for _, ex := range excludes {
-			if ok, _ := filepath.Match(ex, rel); ok {
-				return true
-			}
-		}
-		return false
+	if params.MatchMode == "" {
+		params.MatchMode = "glob"
 	}
-	err = filepath.WalkDir(startDir, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return nil // пропускаем ошибки доступа
+	var include globSet
+	var matcher nameMatcher
+	if params.MatchMode == "glob" {
+		include, err = compileGlobs([]string{params.Pattern})
+		if err == nil && !params.CaseSensitive {
+			include = include.foldCase()
 		}
-		allowed := false
-		for _, root := range allowedDirs {
-			if strings.HasPrefix(path, filepath.Clean(root)) {
-				allowed = true
-				break
+	} else {
+		matcher, err = compileNameMatcher(params)
+	}
+	if err != nil {
+		return errorResult(err)
+	}
+	exclude, err := compileGlobs(params.ExcludePatterns)
+	if err != nil {
+		return errorResult(err)
+	}
+	type searchResult struct {
+		rel, kind   string
+		rank, score int
+	}
+	var results []searchResult
+	ignore := ignoreTreeFor(allowedDirs, startDir, respectGitignore(params.RespectGitignore, true))
+	err = walkEntries(startDir, allowedDirs, include, exclude, ignore, func(path, rel string, d fs.DirEntry) error {
+		score := 0
+		if matcher != nil {
+			var ok bool
+			if score, ok = matcher(rel); !ok {
+				return nil
 			}
 		}
-		if !allowed {
-			return nil
-		}
-		rel, _ := filepath.Rel(startDir, path)
-		matched, _ := filepath.Match(params.Pattern, d.Name())
-		if matched && !isExcluded(rel) {
-			matches = append(matches, rel)
-		}
+		results = append(results, searchResult{rel, entryType(d), nameRank(params.Pattern, rel, params.CaseSensitive), score})
 		return nil
 	})
 	if err != nil {
-		return ToolResult{"content": []map[string]interface{}{{"type": "text", "text": "Error: " + err.Error()}}, "isError": true}, nil
+		return errorResult(err)
 	}
-	var text string
-	if len(matches) > 0 {
-		text = strings.Join(matches, "\n")
-	} else {
-		text = "No matches found"
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].rank != results[j].rank {
+			return results[i].rank < results[j].rank
+		}
+		return results[i].score < results[j].score
+	})
+	matches := make([]map[string]interface{}, 0, len(results))
+	paths := make([]string, 0, len(results))
+	for _, r := range results {
+		matches = append(matches, map[string]interface{}{"path": r.rel, "type": r.kind})
+		paths = append(paths, r.rel)
 	}
-	return ToolResult{"content": []map[string]interface{}{{"type": "text", "text": text}}}, nil
+	text := "No matches found"
+	if len(paths) > 0 {
+		text = strings.Join(paths, "\n")
+	}
+	return ToolResult{"content": []map[string]interface{}{{"type": "text", "text": text}}, "matches": matches}, nil
 }
 
 type ReadMultipleFilesParams struct {
-	Paths []string `json:"paths"`
+	Paths []string `json:"paths" description:"Files to read" required:"true"`
 }
 
 func ReadMultipleFiles(params ReadMultipleFilesParams, allowedDirs []string) (ToolResult, error) {
@@ -260,79 +705,17 @@ func ReadMultipleFiles(params ReadMultipleFilesParams, allowedDirs []string) (To
 }
 
 func ListAllowedDirectories(allowedDirs []string) (ToolResult, error) {
-	return ToolResult{"directories": allowedDirs}, nil
-}
-
-type EditFileParams struct {
-	Path  string `json:"path"`
-	Edits []struct {
-		OldText string `json:"oldText"`
-		NewText string `json:"newText"`
-	} `json:"edits"`
-	DryRun bool `json:"dryRun"`
-}
-
-func EditFile(params EditFileParams, allowedDirs []string) (ToolResult, error) {
-	absPath, err := findAllowedRoot(allowedDirs, params.Path)
-	if err != nil {
-		return nil, err
-	}
-	origData, err := os.ReadFile(absPath)
-	if err != nil {
-		return nil, err
-	}
-	lines := strings.Split(string(origData), "\n")
-	newLines := make([]string, len(lines))
-	copy(newLines, lines)
-	var diff bytes.Buffer
-	changed := false
-
-	for _, edit := range params.Edits {
-		old := edit.OldText
-		newT := edit.NewText
-		match := false
-		for i, line := range newLines {
-			if strings.Contains(line, old) {
-				match = true
-				before := newLines[i]
-				newLines[i] = strings.ReplaceAll(line, old, newT)
-				if before != newLines[i] {
-					changed = true
-					diff.WriteString(fmt.Sprintf("-%s\n+%s\n", before, newLines[i]))
-				}
-			}
-		}
-		if !match {
-			diff.WriteString(fmt.Sprintf("~ not found: %q\n", old))
-		}
-	}
-
-	result := ToolResult{
-		"diff":    diff.String(),
-		"changed": changed,
-	}
-
-	if params.DryRun {
-		result["preview"] = strings.Join(newLines, "\n")
-		return result, nil
-	}
-
-	if changed {
-		joined := strings.Join(newLines, "\n")
-		err = os.WriteFile(absPath, []byte(joined), 0644)
-		if err != nil {
-			return nil, err
-		}
-		result["ok"] = true
-	} else {
-		result["ok"] = false
+	result := ToolResult{"directories": allowedDirs}
+	if readOnly := CurrentPolicy().ReadOnlyRoots; len(readOnly) > 0 {
+		result["readOnly"] = readOnly
 	}
 	return result, nil
 }
 
 type ListDirectoryWithSizesParams struct {
-	Path   string `json:"path"`
-	SortBy string `json:"sortBy"`
+	Path             string `json:"path" description:"Directory path" required:"true"`
+	SortBy           string `json:"sortBy" description:"Sort entries by name or size" enum:"name,size" default:"name"`
+	RespectGitignore *bool  `json:"respectGitignore" description:"Leave out entries ignored by .gitignore, .git/info/exclude and .mcpignore files, and .git directories" default:"false"`
 }
 
 func ListDirectoryWithSizes(params ListDirectoryWithSizesParams, allowedDirs []string) (ToolResult, error) {
@@ -350,8 +733,12 @@ func ListDirectoryWithSizes(params ListDirectoryWithSizesParams, allowedDirs []s
 		IsDir bool
 		Size  int64
 	}
+	ignore := ignoreTreeFor(allowedDirs, absPath, respectGitignore(params.RespectGitignore, false))
 	var infos []entryInfo
 	for _, entry := range entries {
+		if isTrashDir(allowedDirs, filepath.Join(absPath, entry.Name())) || ignore.ignored(filepath.Join(absPath, entry.Name()), entry.IsDir()) {
+			continue
+		}
 		info := entryInfo{Name: entry.Name(), IsDir: entry.IsDir(), Size: 0}
 		if !entry.IsDir() {
 			stat, err := os.Stat(filepath.Join(absPath, entry.Name()))
@@ -402,12 +789,13 @@ func ListDirectoryWithSizes(params ListDirectoryWithSizesParams, allowedDirs []s
 }
 
 type DirectoryTreeParams struct {
-	Path string `json:"path"`
+	Path             string `json:"path" description:"Directory path" required:"true"`
+	RespectGitignore *bool  `json:"respectGitignore" description:"Leave out paths ignored by .gitignore, .git/info/exclude and .mcpignore files, and .git directories" default:"true"`
 }
 
 type TreeEntry struct {
 	Name     string      `json:"name"`
-	Type     string      `json:"type"` // "file" или "directory"
+	Type     string      `json:"type"` // "file", "directory" или "symlink"
 	Children []TreeEntry `json:"children,omitempty"`
 }
 
@@ -416,27 +804,36 @@ func DirectoryTree(params DirectoryTreeParams, allowedDirs []string) (ToolResult
 	if err != nil {
 		return nil, err
 	}
-	entry, err := buildTree(absPath)
+	entry, err := buildTree(absPath, allowedDirs, ignoreTreeFor(allowedDirs, absPath, respectGitignore(params.RespectGitignore, true)))
 	if err != nil {
 		return nil, err
 	}
 	return ToolResult{"tree": entry}, nil
 }
 
-func buildTree(path string) (TreeEntry, error) {
-	info, err := os.Stat(path)
+// buildTree lists path and everything below it, leaving out the trash and
+// paths that ignore (which may be nil) ignores.
+func buildTree(path string, allowedDirs []string, ignore *ignoreTree) (TreeEntry, error) {
+	info, err := os.Lstat(path)
 	if err != nil {
 		return TreeEntry{}, err
 	}
 	entry := TreeEntry{Name: info.Name()}
-	if info.IsDir() {
+	// Symbolic links are listed but never descended into.
+	if info.Mode()&os.ModeSymlink != 0 {
+		entry.Type = "symlink"
+	} else if info.IsDir() {
 		entry.Type = "directory"
 		files, err := os.ReadDir(path)
 		if err != nil {
 			return entry, err
 		}
 		for _, f := range files {
-			child, err := buildTree(filepath.Join(path, f.Name()))
+			childPath := filepath.Join(path, f.Name())
+			if isTrashDir(allowedDirs, childPath) || ignore.ignored(childPath, f.IsDir()) {
+				continue
+			}
+			child, err := buildTree(childPath, allowedDirs, ignore)
 			if err == nil {
 				entry.Children = append(entry.Children, child)
 			}
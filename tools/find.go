@@ -0,0 +1,301 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxFindResults caps the entries find_files returns.
+const defaultMaxFindResults = 1000
+
+// FindFilter is a set of conditions an entry must all meet. Empty fields
+// are not checked.
+type FindFilter struct {
+	Name      []string `json:"name" description:"Globs the path must match (any of them), as in search_files"`
+	Type      string   `json:"type" description:"Entry type" enum:"file,dir,symlink"`
+	MinSize   string   `json:"minSize" description:"Minimum file size, e.g. 512, 10k, 1.5M or 2G"`
+	MaxSize   string   `json:"maxSize" description:"Maximum file size, e.g. 512, 10k, 1.5M or 2G"`
+	NewerThan string   `json:"newerThan" description:"Modified after this: an age such as 2h or 7d, or an RFC 3339 time or date"`
+	OlderThan string   `json:"olderThan" description:"Modified before this: an age such as 2h or 7d, or an RFC 3339 time or date"`
+	Perm      string   `json:"perm" description:"Permission bits in octal, including 4000 setuid, 2000 setgid and 1000 sticky: 644 for exactly these, -111 for all of them, /022 for any of them"`
+	Empty     bool     `json:"empty" description:"Only empty files and directories"`
+}
+
+type FindFilesParams struct {
+	Path string `json:"path" description:"Directory to search" required:"true"`
+	FindFilter
+	AnyOf            []FindFilter `json:"anyOf" description:"Alternative filters; entries must also match at least one of them"`
+	Exclude          []string     `json:"exclude" description:"Globs of paths to skip; excluded directories are not entered"`
+	MinDepth         int          `json:"minDepth" description:"Only entries at least this deep (1 is the directory's own entries)"`
+	MaxDepth         int          `json:"maxDepth" description:"Do not descend below this depth (0 for no limit)"`
+	RespectGitignore *bool        `json:"respectGitignore" description:"Skip paths ignored by .gitignore, .git/info/exclude and .mcpignore files, and .git directories" default:"true"`
+	MaxResults       int          `json:"maxResults" description:"Stop after this many entries" default:"1000"`
+}
+
+// findEntry is a walked entry being tested against filters.
+type findEntry struct {
+	path string
+	rel  string
+	info fs.FileInfo
+}
+
+// findPredicate tests one condition on an entry.
+type findPredicate func(e findEntry) bool
+
+// findExpr is a compiled FindFilter: the conjunction of its predicates.
+type findExpr []findPredicate
+
+func (x findExpr) match(e findEntry) bool {
+	for _, p := range x {
+		if !p(e) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSize parses a byte count with an optional k, M, G or T suffix
+// (powers of 1024, case-insensitive, optionally followed by B).
+func parseSize(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := float64(1)
+	if n := len(str); n > 0 {
+		if i := strings.IndexByte("KMGT", str[n-1]); i >= 0 {
+			mult = float64(int64(1) << (10 * (i + 1)))
+			str = str[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(str, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * mult), nil
+}
+
+// parseTimeBound parses an RFC 3339 time, a date, or an age before now such
+// as "90m", "2h" or "7d". Ages accept the time.ParseDuration units plus d
+// (days) and w (weeks).
+func parseTimeBound(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit > 0 {
+		if n, err := strconv.ParseFloat(s[:len(s)-1], 64); err == nil {
+			return now.Add(-time.Duration(n * float64(unit))), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: use an age such as 2h or 7d, or an RFC 3339 time", s)
+	}
+	return now.Add(-d), nil
+}
+
+// parsePerm parses a find-style permission test. Special bits are given as
+// in chmod(1).
+func parsePerm(s string) (findPredicate, error) {
+	mode, digits := s[0], s
+	if mode == '-' || mode == '/' {
+		digits = s[1:]
+	}
+	bits, err := strconv.ParseUint(digits, 8, 32)
+	if err != nil || bits > 0o7777 {
+		return nil, fmt.Errorf("invalid perm %q", s)
+	}
+	want := fs.FileMode(bits)
+	return func(e findEntry) bool {
+		perm := chmodBits(e.info.Mode())
+		switch mode {
+		case '-':
+			return perm&want == want
+		case '/':
+			return perm&want != 0
+		}
+		return perm == want
+	}, nil
+}
+
+func isEmptyEntry(e findEntry) bool {
+	switch {
+	case e.info.Mode().IsRegular():
+		return e.info.Size() == 0
+	case e.info.IsDir():
+		entries, err := os.ReadDir(e.path)
+		return err == nil && len(entries) == 0
+	}
+	return false
+}
+
+func findEntryType(info fs.FileInfo) string {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		return "symlink"
+	case info.IsDir():
+		return "dir"
+	}
+	return "file"
+}
+
+// compile turns f into predicates, reporting malformed values.
+func (f FindFilter) compile(now time.Time) (findExpr, error) {
+	var x findExpr
+	if len(f.Name) > 0 {
+		names, err := compileGlobs(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		x = append(x, func(e findEntry) bool { return names.match(e.rel, e.info.IsDir()) })
+	}
+	if f.Type != "" {
+		x = append(x, func(e findEntry) bool { return findEntryType(e.info) == f.Type })
+	}
+	for _, bound := range []struct {
+		value string
+		min   bool
+	}{{f.MinSize, true}, {f.MaxSize, false}} {
+		if bound.value == "" {
+			continue
+		}
+		size, err := parseSize(bound.value)
+		if err != nil {
+			return nil, err
+		}
+		atLeast := bound.min
+		// Sizes are only meaningful for regular files.
+		x = append(x, func(e findEntry) bool {
+			if !e.info.Mode().IsRegular() {
+				return false
+			}
+			if atLeast {
+				return e.info.Size() >= size
+			}
+			return e.info.Size() <= size
+		})
+	}
+	if f.NewerThan != "" {
+		t, err := parseTimeBound(f.NewerThan, now)
+		if err != nil {
+			return nil, err
+		}
+		x = append(x, func(e findEntry) bool { return e.info.ModTime().After(t) })
+	}
+	if f.OlderThan != "" {
+		t, err := parseTimeBound(f.OlderThan, now)
+		if err != nil {
+			return nil, err
+		}
+		x = append(x, func(e findEntry) bool { return e.info.ModTime().Before(t) })
+	}
+	if f.Perm != "" {
+		p, err := parsePerm(f.Perm)
+		if err != nil {
+			return nil, err
+		}
+		x = append(x, p)
+	}
+	if f.Empty {
+		x = append(x, isEmptyEntry)
+	}
+	return x, nil
+}
+
+// FindFiles lists entries below a directory that match every top-level
+// filter and, when anyOf is given, at least one of its filters. Entries are
+// returned in walk order with their type, size (for files and symlinks),
+// modification time and permissions.
+func FindFiles(params FindFilesParams, allowedDirs []string) (ToolResult, error) {
+	startDir, err := findAllowedRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	if params.MinDepth < 0 || params.MaxDepth < 0 {
+		return nil, errors.New("depth limits must not be negative")
+	}
+	now := time.Now()
+	all, err := params.FindFilter.compile(now)
+	if err != nil {
+		return nil, err
+	}
+	var anyOf []findExpr
+	for i, f := range params.AnyOf {
+		x, err := f.compile(now)
+		if err != nil {
+			return nil, fmt.Errorf("anyOf[%d]: %v", i, err)
+		}
+		anyOf = append(anyOf, x)
+	}
+	exclude, err := compileGlobs(params.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	limit := params.MaxResults
+	if limit <= 0 {
+		limit = defaultMaxFindResults
+	}
+
+	entries := []map[string]interface{}{}
+	truncated := false
+	ignore := ignoreTreeFor(allowedDirs, startDir, respectGitignore(params.RespectGitignore, true))
+	err = walkEntries(startDir, allowedDirs, nil, exclude, ignore, func(path, rel string, d fs.DirEntry) error {
+		depth := strings.Count(rel, "/") + 1
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		e := findEntry{path: path, rel: rel, info: info}
+		matched := depth >= params.MinDepth && all.match(e)
+		if matched && len(anyOf) > 0 {
+			matched = false
+			for _, x := range anyOf {
+				if x.match(e) {
+					matched = true
+					break
+				}
+			}
+		}
+		if matched {
+			if len(entries) == limit {
+				truncated = true
+				return errSearchLimit
+			}
+			entry := map[string]interface{}{
+				"path":  rel,
+				"type":  findEntryType(info),
+				"mtime": info.ModTime().UTC().Format(time.RFC3339),
+				"mode":  octalMode(info.Mode()),
+			}
+			if !info.IsDir() {
+				entry["size"] = info.Size()
+			}
+			entries = append(entries, entry)
+		}
+		if d.IsDir() && params.MaxDepth > 0 && depth >= params.MaxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil && err != errSearchLimit {
+		return nil, err
+	}
+	return ToolResult{
+		"entries":   entries,
+		"total":     len(entries),
+		"truncated": truncated,
+	}, nil
+}
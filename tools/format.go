@@ -0,0 +1,312 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultFormatIndent = "  "
+
+// formatters canonicalize normalized text (LF line endings, no BOM) by file
+// extension. indent is used by formats whose indentation is configurable.
+var formatters = map[string]func(text, indent string) (string, error){
+	".go":   formatGo,
+	".json": formatJSON,
+	".xml":  formatXML,
+}
+
+// ParseFormatExtensions parses a comma-separated list of extensions such as
+// ".go,.json". Extensions without a formatter are rejected.
+func ParseFormatExtensions(list string) ([]string, error) {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if _, ok := formatters[ext]; !ok {
+			return nil, fmt.Errorf("no formatter for %s files (supported: .go, .json, .xml)", ext)
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+func formatGo(text, indent string) (string, error) {
+	out, err := format.Source([]byte(text))
+	if err != nil {
+		if serr := checkGoSyntax("", []byte(text)); serr != nil {
+			return "", serr
+		}
+		return "", err
+	}
+	return string(out), nil
+}
+
+func formatJSON(text, indent string) (string, error) {
+	if serr := checkJSONSyntax("", []byte(text)); serr != nil {
+		return "", serr
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(strings.TrimSpace(text)), "", indent); err != nil {
+		return "", err
+	}
+	buf.WriteByte('\n')
+	return buf.String(), nil
+}
+
+// xmlNode is an element, or a run of character data when start is nil, or
+// any other token (comment, processing instruction, directive) kept as raw
+// markup. Elements also keep their source text, from the start tag to the
+// end tag.
+type xmlNode struct {
+	start    *xml.StartElement
+	text     *string
+	raw      string
+	source   string
+	offset   int64
+	children []*xmlNode
+}
+
+func xmlName(n xml.Name) string {
+	if n.Space != "" {
+		return n.Space + ":" + n.Local
+	}
+	return n.Local
+}
+
+var (
+	xmlTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	xmlAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;", "\n", "&#xA;", "\t", "&#x9;")
+)
+
+// parseXMLNodes reads a document into a tree. Prefixes are kept as written.
+func parseXMLNodes(text string) ([]*xmlNode, error) {
+	if serr := checkXMLSyntax("", []byte(text)); serr != nil {
+		return nil, serr
+	}
+	dec := xml.NewDecoder(strings.NewReader(text))
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	for {
+		offset := dec.InputOffset()
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			return root.children, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			start := t.Copy()
+			node := &xmlNode{start: &start, offset: offset}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			parent.source = text[parent.offset:dec.InputOffset()]
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			s := string(t)
+			parent.children = append(parent.children, &xmlNode{text: &s})
+		case xml.Comment:
+			parent.children = append(parent.children, &xmlNode{raw: "<!--" + string(t) + "-->"})
+		case xml.ProcInst:
+			raw := "<?" + t.Target
+			if len(t.Inst) > 0 {
+				raw += " " + string(t.Inst)
+			}
+			parent.children = append(parent.children, &xmlNode{raw: raw + "?>"})
+		case xml.Directive:
+			parent.children = append(parent.children, &xmlNode{raw: "<!" + string(t) + ">"})
+		}
+	}
+}
+
+// preservesSpace reports whether an element asks for its whitespace to be
+// kept with xml:space="preserve".
+func preservesSpace(start *xml.StartElement) bool {
+	for _, a := range start.Attr {
+		if a.Name.Space == "xml" && a.Name.Local == "space" {
+			return a.Value == "preserve"
+		}
+	}
+	return false
+}
+
+func writeXMLNode(buf *strings.Builder, n *xmlNode, indent string, depth int) {
+	pad := strings.Repeat(indent, depth)
+	switch {
+	case n.text != nil:
+		if s := strings.TrimSpace(*n.text); s != "" {
+			buf.WriteString(pad + xmlTextEscaper.Replace(s) + "\n")
+		}
+		return
+	case n.start == nil:
+		buf.WriteString(pad + n.raw + "\n")
+		return
+	}
+	var elements int
+	var text strings.Builder
+	for _, c := range n.children {
+		if c.text != nil {
+			text.WriteString(*c.text)
+		} else {
+			elements++
+		}
+	}
+	// Mixed content and preserved whitespace are written as they were:
+	// re-indenting them would change the text.
+	if preservesSpace(n.start) || elements > 0 && strings.TrimSpace(text.String()) != "" {
+		buf.WriteString(pad + n.source + "\n")
+		return
+	}
+	buf.WriteString(pad + "<" + xmlName(n.start.Name))
+	for _, a := range n.start.Attr {
+		buf.WriteString(" " + xmlName(a.Name) + "=\"" + xmlAttrEscaper.Replace(a.Value) + "\"")
+	}
+	switch {
+	case elements == 0 && text.Len() == 0:
+		buf.WriteString("/>\n")
+	case elements == 0:
+		// Text-only content is kept exactly.
+		buf.WriteString(">" + xmlTextEscaper.Replace(text.String()) + "</" + xmlName(n.start.Name) + ">\n")
+	default:
+		buf.WriteString(">\n")
+		for _, c := range n.children {
+			writeXMLNode(buf, c, indent, depth+1)
+		}
+		buf.WriteString(pad + "</" + xmlName(n.start.Name) + ">\n")
+	}
+}
+
+// formatXML re-indents a document: one element per line, with elements that
+// contain only text kept on a single line. Whitespace between elements is
+// replaced. Elements mixing text with elements, and those marked
+// xml:space="preserve", are written as they were.
+func formatXML(text, indent string) (string, error) {
+	nodes, err := parseXMLNodes(text)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	for _, n := range nodes {
+		writeXMLNode(&buf, n, indent, 0)
+	}
+	return buf.String(), nil
+}
+
+// formatText formats normalized text with the formatter for path's extension.
+func formatText(path, text, indent string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	f, ok := formatters[ext]
+	if !ok {
+		return "", fmt.Errorf("no formatter for %q files (supported: .go, .json, .xml)", ext)
+	}
+	if indent == "" {
+		indent = defaultFormatIndent
+	}
+	return f(text, indent)
+}
+
+// formatOnWrite formats data when the policy formats path's extension on
+// write, keeping its line endings and BOM. If data cannot be formatted it is
+// returned unchanged with the error.
+func formatOnWrite(path string, data []byte) ([]byte, bool, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	enabled := false
+	for _, e := range CurrentPolicy().FormatOnWriteExtensions {
+		enabled = enabled || e == ext
+	}
+	if !enabled {
+		return data, false, nil
+	}
+	text, style := decodeText(data)
+	formatted, err := formatText(path, text, "")
+	if err != nil {
+		return data, false, err
+	}
+	if formatted == text {
+		return data, false, nil
+	}
+	return encodeText(formatted, style), true, nil
+}
+
+// addFormatResult reports the outcome of formatOnWrite in a tool result.
+func addFormatResult(result ToolResult, formatted bool, err error) {
+	if formatted {
+		result["formatted"] = true
+	}
+	if err != nil {
+		result["formatError"] = err.Error()
+	}
+}
+
+type FormatFileParams struct {
+	Path   string `json:"path" description:"File to format (.go, .json or .xml)" required:"true"`
+	Indent string `json:"indent" description:"Indentation for JSON and XML, e.g. two spaces or a tab" default:"  "`
+	DryRun bool   `json:"dryRun" description:"Return the formatted content and a diff without writing"`
+}
+
+// FormatFile canonicalizes a Go, JSON or XML file: go/format for Go,
+// json.Indent for JSON and re-indentation for XML. Line endings and BOM are
+// kept.
+func FormatFile(params FormatFileParams, allowedDirs []string) (ToolResult, error) {
+	absPath, err := findWritableRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("path is a directory")
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	text, style := decodeText(data)
+	formatted, err := formatText(absPath, text, params.Indent)
+	if err != nil {
+		return nil, err
+	}
+	changed := formatted != text
+	oldName, newName := diffNames(allowedDirs, absPath)
+	result := ToolResult{
+		"ok":      true,
+		"changed": changed,
+		"diff":    unifiedDiff(oldName, newName, text, formatted, defaultContextLines),
+	}
+	if params.DryRun {
+		result["dryRun"] = true
+		result["content"] = formatted
+		return result, nil
+	}
+	if changed {
+		written, err := writeChecked(absPath, encodeText(formatted, style), info.Mode().Perm())
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range written {
+			result[k] = v
+		}
+	}
+	return result, nil
+}
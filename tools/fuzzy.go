@@ -0,0 +1,179 @@
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Match modes accepted by EditOperation.Match.
+const (
+	matchExact      = "exact"
+	matchWhitespace = "whitespace"
+)
+
+// defaultMinConfidence rejects fuzzy matches that needed more than a
+// consistent change of indentation.
+const defaultMinConfidence = 0.8
+
+// Normalization levels tried by fuzzy matching, from least to most lenient.
+// Each level's confidence is what a match needing it is reported with.
+var fuzzyLevels = []struct {
+	name       string
+	confidence float64
+	equal      func(a, b string) bool
+}{
+	{"none", 1, func(a, b string) bool { return a == b }},
+	{"trailingWhitespace", 0.95, func(a, b string) bool {
+		return strings.TrimRight(a, " \t") == strings.TrimRight(b, " \t")
+	}},
+	{"indentation", 0.9, func(a, b string) bool {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}},
+	{"whitespace", 0.6, func(a, b string) bool {
+		return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+	}},
+}
+
+// inconsistentIndentConfidence is used for indentation matches whose lines
+// don't map onto the file's indentation consistently.
+const inconsistentIndentConfidence = 0.7
+
+// matchInfo describes how an edit's oldText was matched.
+type matchInfo struct {
+	Normalization string
+	Confidence    float64
+	Reindented    bool
+	// Replacements counts the matches replaced, which may share a hunk.
+	Replacements int
+	// Matches lists each regex replacement with its line.
+	Matches []map[string]interface{}
+}
+
+func leadingWhitespace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+// indentMap pairs each indentation used in want with the one used on the
+// matching line of found. It reports false when the pairing is inconsistent:
+// an indentation maps to two different ones, or nesting order is not kept.
+func indentMap(want, found []string) (map[string]string, bool) {
+	m := map[string]string{}
+	for i := range want {
+		if strings.TrimSpace(want[i]) == "" {
+			continue
+		}
+		w, f := leadingWhitespace(want[i]), leadingWhitespace(found[i])
+		if prev, ok := m[w]; ok && prev != f {
+			return m, false
+		}
+		m[w] = f
+	}
+	for w1, f1 := range m {
+		for w2, f2 := range m {
+			if (len(w1) < len(w2)) != (len(f1) < len(f2)) {
+				return m, false
+			}
+		}
+	}
+	return m, true
+}
+
+// reindent rewrites the indentation of lines using m. The longest mapped
+// indentation that prefixes a line is replaced; unmapped lines keep theirs.
+func reindent(lines []string, m map[string]string) ([]string, bool) {
+	out := make([]string, len(lines))
+	changed := false
+	for i, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			out[i] = ""
+			continue
+		}
+		indent := leadingWhitespace(l)
+		best, ok := "", false
+		for w := range m {
+			if strings.HasPrefix(indent, w) && (!ok || len(w) > len(best)) {
+				best, ok = w, true
+			}
+		}
+		out[i] = l
+		if ok {
+			out[i] = m[best] + l[len(best):]
+		}
+		changed = changed || out[i] != l
+	}
+	return out, changed
+}
+
+// fuzzyMatches finds blocks of whole lines that equal old once whitespace is
+// normalized. Each window is scored by the most lenient level it needed, and
+// windows scoring below minConfidence are rejected. For indentation matches
+// newText is reindented to the indentation found in the file.
+func fuzzyMatches(content, old, newText string, minConfidence float64) ([]textMatch, matchInfo, error) {
+	info := matchInfo{}
+	if minConfidence <= 0 {
+		minConfidence = defaultMinConfidence
+	}
+	if strings.TrimSpace(old) == "" {
+		return nil, info, fmt.Errorf("oldText is blank and cannot be matched by whitespace")
+	}
+	trailing := strings.HasSuffix(old, "\n")
+	want := strings.Split(strings.TrimSuffix(old, "\n"), "\n")
+	if trailing {
+		newText = strings.TrimSuffix(newText, "\n")
+	}
+	newLines := strings.Split(newText, "\n")
+
+	lines := strings.Split(content, "\n")
+	starts := make([]int, len(lines)+1)
+	for i, l := range lines {
+		starts[i+1] = starts[i] + len(l) + 1
+	}
+
+	var matches []textMatch
+	best := -1.0
+	bestLine := 0
+	for i := 0; i+len(want) <= len(lines); {
+		found := lines[i : i+len(want)]
+		level := 0
+		for k := range want {
+			for level < len(fuzzyLevels) && !fuzzyLevels[level].equal(want[k], found[k]) {
+				level++
+			}
+		}
+		if level == len(fuzzyLevels) {
+			i++
+			continue
+		}
+		confidence := fuzzyLevels[level].confidence
+		indents, consistent := indentMap(want, found)
+		if fuzzyLevels[level].name == "indentation" && !consistent {
+			confidence = inconsistentIndentConfidence
+		}
+		if confidence > best {
+			best, bestLine = confidence, i+1
+		}
+		if confidence < minConfidence {
+			i++
+			continue
+		}
+		text := newLines
+		if level >= 2 {
+			var changed bool
+			text, changed = reindent(newLines, indents)
+			info.Reindented = info.Reindented || changed
+		}
+		end := starts[i+len(want)] - 1
+		if end > len(content) {
+			end = len(content)
+		}
+		matches = append(matches, textMatch{Start: starts[i], End: end, Text: strings.Join(text, "\n")})
+		if info.Normalization == "" || confidence < info.Confidence {
+			info.Normalization, info.Confidence = fuzzyLevels[level].name, confidence
+		}
+		i += len(want)
+	}
+	if len(matches) == 0 && best >= 0 {
+		return nil, info, fmt.Errorf("closest match at line %d has confidence %.2f, below minConfidence %.2f", bestLine, best, minConfidence)
+	}
+	return matches, info, nil
+}
@@ -0,0 +1,215 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Globs follow gitignore conventions. A pattern without a slash matches the
+// name of an entry at any depth. A pattern with a slash is matched against
+// the whole path relative to the start directory, where * and ? do not cross
+// slashes and a ** segment matches any number of directories. A trailing
+// slash only matches directories. Braces expand to alternatives, so
+// *.{go,mod} is *.go or *.mod.
+
+type globPattern struct {
+	segments []string
+	anchored bool
+	dirOnly  bool
+	fold     bool
+}
+
+// globSet matches a path if any of its patterns does.
+type globSet []globPattern
+
+// expandBraces returns the alternatives of the first brace group in pattern,
+// each expanded in turn. Unbalanced braces are kept literally.
+func expandBraces(pattern string) []string {
+	depth, start := 0, -1
+	var commas []int
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '{':
+			if depth == 0 {
+				start = i
+				commas = nil
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if len(commas) == 0 {
+				// {x} has no alternatives; keep it and expand the rest.
+				var out []string
+				for _, rest := range expandBraces(pattern[i+1:]) {
+					out = append(out, pattern[:i+1]+rest)
+				}
+				return out
+			}
+			prefix, suffix := pattern[:start], pattern[i+1:]
+			bounds := append(append([]int{start}, commas...), i)
+			var out []string
+			for k := 0; k+1 < len(bounds); k++ {
+				alt := pattern[bounds[k]+1 : bounds[k+1]]
+				out = append(out, expandBraces(prefix+alt+suffix)...)
+			}
+			return out
+		}
+	}
+	return []string{pattern}
+}
+
+// parseGlob parses a single pattern without braces.
+func parseGlob(pattern string) (globPattern, error) {
+	g := globPattern{}
+	if strings.HasSuffix(pattern, "/") {
+		g.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if strings.HasPrefix(pattern, "/") {
+		g.anchored = true
+		pattern = strings.TrimLeft(pattern, "/")
+	}
+	if pattern == "" {
+		return g, errors.New("empty pattern")
+	}
+	g.segments = strings.Split(pattern, "/")
+	if len(g.segments) > 1 {
+		g.anchored = true
+	}
+	for _, seg := range g.segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// compileGlobs parses patterns into a globSet, reporting malformed ones.
+func compileGlobs(patterns []string) (globSet, error) {
+	var set globSet
+	for _, p := range patterns {
+		for _, alt := range expandBraces(p) {
+			g, err := parseGlob(alt)
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %v", p, err)
+			}
+			set = append(set, g)
+		}
+	}
+	return set, nil
+}
+
+// matchSegments matches path segments against pattern segments, letting **
+// stand for zero or more segments.
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for len(pattern) > 0 && pattern[0] == "**" {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern, segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// match reports whether rel, a slash-separated path relative to the start
+// directory, matches g.
+func (g globPattern) match(rel string, isDir bool) bool {
+	if g.dirOnly && !isDir {
+		return false
+	}
+	if g.fold {
+		rel = strings.ToLower(rel)
+	}
+	if !g.anchored {
+		return matchSegments(g.segments, []string{path.Base(rel)})
+	}
+	return matchSegments(g.segments, strings.Split(rel, "/"))
+}
+
+// mayMatchBelow reports whether some path inside the directory rel could
+// match g, so walks can skip directories that cannot contain matches.
+func (g globPattern) mayMatchBelow(rel string) bool {
+	if !g.anchored {
+		return true
+	}
+	if g.fold {
+		rel = strings.ToLower(rel)
+	}
+	pattern, segs := g.segments, strings.Split(rel, "/")
+	for len(segs) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(pattern) > 0
+}
+
+// foldCase returns a copy of s that ignores case.
+func (s globSet) foldCase() globSet {
+	out := make(globSet, len(s))
+	for i, g := range s {
+		segs := make([]string, len(g.segments))
+		for j, seg := range g.segments {
+			segs[j] = strings.ToLower(seg)
+		}
+		g.segments, g.fold = segs, true
+		out[i] = g
+	}
+	return out
+}
+
+func (s globSet) match(rel string, isDir bool) bool {
+	for _, g := range s {
+		if g.match(rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s globSet) mayMatchBelow(rel string) bool {
+	for _, g := range s {
+		if g.mayMatchBelow(rel) {
+			return true
+		}
+	}
+	return false
+}
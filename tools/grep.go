@@ -0,0 +1,168 @@
+package tools
+
+import (
+	"errors"
+	"io/fs"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// defaultMaxGrepMatches caps the matches grep_files returns in total.
+	defaultMaxGrepMatches = 1000
+	// maxGrepLineLength caps the text returned for a matching line; longer
+	// lines are cut to a window around the match.
+	maxGrepLineLength = 1000
+)
+
+type GrepFilesParams struct {
+	Path              string   `json:"path" description:"Directory to search" required:"true"`
+	Pattern           string   `json:"pattern" description:"Text or regular expression to find" required:"true"`
+	Regex             bool     `json:"regex" description:"Treat pattern as a Go regular expression"`
+	CaseInsensitive   bool     `json:"caseInsensitive" description:"Ignore case"`
+	WholeWord         bool     `json:"wholeWord" description:"Only match whole words"`
+	Include           []string `json:"include" description:"Globs of files to include"`
+	Exclude           []string `json:"exclude" description:"Globs of files and directories to skip"`
+	BeforeContext     int      `json:"beforeContext" description:"Lines of context before each match"`
+	AfterContext      int      `json:"afterContext" description:"Lines of context after each match"`
+	MaxMatchesPerFile int      `json:"maxMatchesPerFile" description:"Stop searching a file after this many matches (0 for no limit)"`
+	MaxMatches        int      `json:"maxMatches" description:"Stop the search after this many matches in total" default:"1000"`
+	RespectGitignore  *bool    `json:"respectGitignore" description:"Skip paths ignored by .gitignore, .git/info/exclude and .mcpignore files, and .git directories" default:"true"`
+}
+
+// compileGrepPattern builds the regular expression for a search. Literal
+// patterns are quoted, and whole-word searches are anchored at word
+// boundaries.
+func compileGrepPattern(params GrepFilesParams) (*regexp.Regexp, error) {
+	expr := params.Pattern
+	if !params.Regex {
+		expr = regexp.QuoteMeta(expr)
+	}
+	if params.WholeWord {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if params.CaseInsensitive {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// clipLine returns line, or a window of it around [start, end) when it is
+// longer than maxGrepLineLength.
+func clipLine(line string, start, end int) string {
+	if len(line) <= maxGrepLineLength {
+		return line
+	}
+	from := start - (maxGrepLineLength-(end-start))/2
+	if from < 0 {
+		from = 0
+	}
+	to := from + maxGrepLineLength
+	if to > len(line) {
+		to = len(line)
+	}
+	for from > 0 && !utf8.RuneStart(line[from]) {
+		from--
+	}
+	for to < len(line) && !utf8.RuneStart(line[to]) {
+		to++
+	}
+	return line[from:to]
+}
+
+func contextSlice(lines []string, from, to int) []string {
+	if from < 0 {
+		from = 0
+	}
+	if to > len(lines) {
+		to = len(lines)
+	}
+	out := make([]string, 0, to-from)
+	for _, l := range lines[from:to] {
+		out = append(out, clipLine(l, 0, 0))
+	}
+	return out
+}
+
+var errSearchLimit = errors.New("search limit reached")
+
+// GrepFiles searches the content of text files below a directory. Binary
+// files and files over 10 MiB are skipped. Each match is reported with its
+// path, 1-based line and column (in characters) and the matching line.
+func GrepFiles(params GrepFilesParams, allowedDirs []string) (ToolResult, error) {
+	if params.Pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	startDir, err := findAllowedRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	re, err := compileGrepPattern(params)
+	if err != nil {
+		return nil, err
+	}
+	if params.BeforeContext < 0 || params.AfterContext < 0 {
+		return nil, errors.New("context lines must not be negative")
+	}
+	limit := params.MaxMatches
+	if limit <= 0 {
+		limit = defaultMaxGrepMatches
+	}
+
+	matches := []map[string]interface{}{}
+	searched, matched, skipped := 0, 0, 0
+	truncated := false
+	err = walkFiles(startDir, allowedDirs, params.Include, params.Exclude, ignoreTreeFor(allowedDirs, startDir, respectGitignore(params.RespectGitignore, true)), func(path, rel string, info fs.FileInfo) error {
+		data, ok, err := readTextFile(path, info)
+		if err != nil || !ok {
+			skipped++
+			return nil
+		}
+		searched++
+		lines := strings.Split(strings.TrimSuffix(normalizeText(data), "\n"), "\n")
+		inFile := 0
+		for i, line := range lines {
+			for _, loc := range re.FindAllStringIndex(line, -1) {
+				if len(matches) == limit {
+					truncated = true
+					return errSearchLimit
+				}
+				if params.MaxMatchesPerFile > 0 && inFile == params.MaxMatchesPerFile {
+					truncated = true
+					return nil
+				}
+				m := map[string]interface{}{
+					"path":   rel,
+					"line":   i + 1,
+					"column": utf8.RuneCountInString(line[:loc[0]]) + 1,
+					"text":   clipLine(line, loc[0], loc[1]),
+					"match":  line[loc[0]:loc[1]],
+				}
+				if params.BeforeContext > 0 {
+					m["before"] = contextSlice(lines, i-params.BeforeContext, i)
+				}
+				if params.AfterContext > 0 {
+					m["after"] = contextSlice(lines, i+1, i+1+params.AfterContext)
+				}
+				matches = append(matches, m)
+				if inFile == 0 {
+					matched++
+				}
+				inFile++
+			}
+		}
+		return nil
+	})
+	if err != nil && err != errSearchLimit {
+		return nil, err
+	}
+	return ToolResult{
+		"matches":       matches,
+		"totalMatches":  len(matches),
+		"filesSearched": searched,
+		"filesMatched":  matched,
+		"filesSkipped":  skipped,
+		"truncated":     truncated,
+	}, nil
+}
@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are read in every directory, in increasing precedence.
+// .git/info/exclude is read in directories containing .git, before them.
+var ignoreFiles = []string{".gitignore", ".mcpignore"}
+
+// ignoreRule is one line of an ignore file. Its pattern is matched against
+// paths relative to base, the directory holding the file.
+type ignoreRule struct {
+	base    string
+	pattern globPattern
+	negate  bool
+}
+
+// parseIgnoreLine parses a gitignore line. Blank lines and comments give
+// ok == false, as do malformed patterns, which git also ignores.
+func parseIgnoreLine(line string) (pattern string, negate, ok bool) {
+	line = strings.TrimSuffix(line, "\r")
+	// Trailing spaces are dropped unless escaped with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false, false
+	}
+	if strings.HasPrefix(line, "!") {
+		negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	return line, negate, line != ""
+}
+
+func readIgnoreFile(path, base string) []ignoreRule {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		pattern, negate, ok := parseIgnoreLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		g, err := parseGlob(pattern)
+		if err != nil {
+			continue
+		}
+		rules = append(rules, ignoreRule{base: base, pattern: g, negate: negate})
+	}
+	return rules
+}
+
+// ignoreTree answers whether paths below root are ignored by the
+// .gitignore, .git/info/exclude and .mcpignore files between root and them.
+// Rules are loaded once per directory.
+type ignoreTree struct {
+	root  string
+	rules map[string][]ignoreRule
+}
+
+func newIgnoreTree(root string) *ignoreTree {
+	return &ignoreTree{root: root, rules: map[string][]ignoreRule{}}
+}
+
+// ignoreTreeFor returns the ignoreTree for walks of absPath, or nil when
+// respect is false. It is rooted at the repository enclosing absPath's
+// allowed root, so rules from ignore files above the allowed root apply as
+// they do in git; those files are only read. Outside a repository it is
+// rooted at the allowed root.
+func ignoreTreeFor(allowedDirs []string, absPath string, respect bool) *ignoreTree {
+	if !respect {
+		return nil
+	}
+	root, err := rootFor(allowedDirs, absPath)
+	if err != nil {
+		return nil
+	}
+	return newIgnoreTree(repositoryRoot(root))
+}
+
+// repositoryRoot returns the nearest directory at or above dir that holds
+// .git, or dir when there is none.
+func repositoryRoot(dir string) string {
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// respectGitignore resolves an optional respectGitignore parameter, which is
+// def when absent.
+func respectGitignore(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+func (t *ignoreTree) dirRules(dir string) []ignoreRule {
+	if rules, ok := t.rules[dir]; ok {
+		return rules
+	}
+	var rules []ignoreRule
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		rules = append(rules, readIgnoreFile(filepath.Join(dir, ".git", "info", "exclude"), dir)...)
+	}
+	for _, name := range ignoreFiles {
+		rules = append(rules, readIgnoreFile(filepath.Join(dir, name), dir)...)
+	}
+	t.rules[dir] = rules
+	return rules
+}
+
+// ignored reports whether path is ignored. As in git, the last matching
+// rule wins, rules in deeper directories take precedence, and a negation
+// cannot re-include a path whose parent directory is ignored; callers that
+// prune ignored directories get that for free. The .git directory itself is
+// always ignored.
+func (t *ignoreTree) ignored(path string, isDir bool) bool {
+	if t == nil {
+		return false
+	}
+	if isDir && filepath.Base(path) == ".git" {
+		return true
+	}
+	rel, err := filepath.Rel(t.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	dirs := []string{t.root}
+	parts := strings.Split(filepath.Dir(rel), string(filepath.Separator))
+	if parts[0] != "." {
+		for i := range parts {
+			dirs = append(dirs, filepath.Join(t.root, filepath.Join(parts[:i+1]...)))
+		}
+	}
+	ignored := false
+	for _, dir := range dirs {
+		for _, r := range t.dirRules(dir) {
+			relToBase, err := filepath.Rel(r.base, path)
+			if err != nil {
+				continue
+			}
+			if r.pattern.match(filepath.ToSlash(relToBase), isDir) {
+				ignored = !r.negate
+			}
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,576 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// journalMaxEntries is how many operations each session can undo.
+	journalMaxEntries = 100
+	// journalSnapshotLimit caps the bytes captured for one side (before or
+	// after) of an operation. Larger operations are journaled as irreversible.
+	journalSnapshotLimit = 32 << 20
+	// journalSessionLimit caps the snapshot bytes a session's journal holds.
+	// The oldest entries are forgotten to stay under it.
+	journalSessionLimit = 256 << 20
+)
+
+// DefaultSession is the journal used when a request carries no MCP session.
+const DefaultSession = "default"
+
+// nodeState records one entry of a snapshotted tree. UID and GID are -1
+// when ownership is unknown. ChangeTime is only used to notice metadata
+// changes and cannot be restored.
+type nodeState struct {
+	Rel        string
+	Mode       fs.FileMode
+	Content    []byte
+	Link       string
+	ModTime    time.Time
+	AccessTime time.Time
+	ChangeTime time.Time
+	UID, GID   int
+}
+
+// pathState is the state of a path at a point in time. For directories it
+// holds every entry below it, sorted by relative path.
+type pathState struct {
+	Path   string
+	Exists bool
+	Nodes  []nodeState
+}
+
+func snapshotPath(absPath string, budget *int64) (pathState, error) {
+	state := pathState{Path: absPath}
+	if _, err := os.Lstat(absPath); os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+	state.Exists = true
+	err := filepath.WalkDir(absPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(absPath, p)
+		if d.IsDir() && p != absPath && filepath.Base(p) == trashDirName {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		node := nodeState{Rel: rel, Mode: info.Mode(), ModTime: info.ModTime()}
+		node.UID, node.GID, node.AccessTime, node.ChangeTime = statDetails(info)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			node.Link, err = os.Readlink(p)
+		case info.Mode().IsRegular():
+			*budget -= info.Size()
+			if *budget < 0 {
+				return errSnapshotTooLarge
+			}
+			node.Content, err = os.ReadFile(p)
+		}
+		if err != nil {
+			return err
+		}
+		state.Nodes = append(state.Nodes, node)
+		return nil
+	})
+	sort.Slice(state.Nodes, func(i, j int) bool { return state.Nodes[i].Rel < state.Nodes[j].Rel })
+	return state, err
+}
+
+var errSnapshotTooLarge = errors.New("operation is too large to journal")
+
+// equal reports whether two states have the same entries, content, modes,
+// owners and modification times. Access times are not compared since reads
+// change them. Symbolic link times are not compared since they cannot be
+// restored portably.
+func (s pathState) equal(o pathState) bool {
+	if s.Exists != o.Exists || len(s.Nodes) != len(o.Nodes) {
+		return false
+	}
+	for i := range s.Nodes {
+		a, b := s.Nodes[i], o.Nodes[i]
+		if a.Rel != b.Rel || a.Mode != b.Mode || a.Link != b.Link || !bytes.Equal(a.Content, b.Content) ||
+			a.UID != b.UID || a.GID != b.GID {
+			return false
+		}
+		if a.Mode&os.ModeSymlink == 0 && !a.ModTime.Equal(b.ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// touched reports whether anything about the path changed between s and o,
+// including metadata that equal ignores, such as a new access time.
+func (s pathState) touched(o pathState) bool {
+	if !s.equal(o) {
+		return true
+	}
+	for i := range s.Nodes {
+		if !s.Nodes[i].ChangeTime.Equal(o.Nodes[i].ChangeTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s pathState) size() int64 {
+	var n int64
+	for _, node := range s.Nodes {
+		n += int64(len(node.Content))
+	}
+	return n
+}
+
+// restoreMeta applies a node's mode, owner and times to target.
+func restoreMeta(target string, node nodeState) error {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return err
+	}
+	uid, gid, _, _ := statDetails(info)
+	if node.UID >= 0 && (node.UID != uid || node.GID != gid) {
+		// Only root can give files away; other users keep the new owner
+		// rather than stopping halfway through a restore.
+		if err := os.Lchown(target, node.UID, node.GID); err != nil && !errors.Is(err, fs.ErrPermission) {
+			return err
+		}
+	}
+	if node.Mode&os.ModeSymlink != 0 {
+		return nil
+	}
+	if err := os.Chmod(target, node.Mode&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+		return err
+	}
+	return os.Chtimes(target, node.AccessTime, node.ModTime)
+}
+
+// restore makes s.Path match the recorded state. Entries that already exist
+// with the right type are updated in place, so files keep their identity and
+// hard links; only entries the state lacks, or of another type, are removed.
+func (s pathState) restore() error {
+	if !s.Exists {
+		return os.RemoveAll(s.Path)
+	}
+	want := map[string]fs.FileMode{}
+	for _, node := range s.Nodes {
+		want[node.Rel] = node.Mode.Type()
+	}
+	var stale []string
+	filepath.WalkDir(s.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(s.Path, p)
+		if d.IsDir() && p != s.Path && filepath.Base(p) == trashDirName {
+			return filepath.SkipDir
+		}
+		if mode, ok := want[rel]; !ok || mode != d.Type() {
+			stale = append(stale, p)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	for _, p := range stale {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0755); err != nil {
+		return err
+	}
+	var dirs []nodeState
+	for _, node := range s.Nodes {
+		target := filepath.Join(s.Path, node.Rel)
+		_, statErr := os.Lstat(target)
+		exists := statErr == nil
+		var err error
+		switch {
+		case node.Mode.IsDir():
+			if !exists {
+				err = os.Mkdir(target, 0700)
+			}
+			dirs = append(dirs, node)
+		case node.Mode&os.ModeSymlink != 0:
+			if link, _ := os.Readlink(target); !exists || link != node.Link {
+				os.Remove(target)
+				err = os.Symlink(node.Link, target)
+			}
+		default:
+			if current, readErr := os.ReadFile(target); !exists || readErr != nil || !bytes.Equal(current, node.Content) {
+				if exists {
+					// Make sure the file can be rewritten; restoreMeta resets the mode.
+					os.Chmod(target, node.Mode.Perm()|0200)
+				}
+				err = os.WriteFile(target, node.Content, node.Mode.Perm())
+			}
+		}
+		if err == nil && !node.Mode.IsDir() {
+			err = restoreMeta(target, node)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restoreMeta(filepath.Join(s.Path, dirs[i].Rel), dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalEntry is one mutation with the state of every affected path before
+// and after it. Irreversible entries have a reason instead of states.
+// Trashed maps paths the operation moved to the trash to their trash IDs.
+type journalEntry struct {
+	ID           int
+	Tool         string
+	Time         time.Time
+	Before       []pathState
+	After        []pathState
+	Trashed      map[string]string
+	Irreversible string
+	size         int64
+}
+
+func (e *journalEntry) summary() map[string]interface{} {
+	paths := make([]string, len(e.Before))
+	for i, s := range e.Before {
+		paths[i] = s.Path
+	}
+	m := map[string]interface{}{
+		"id":    e.ID,
+		"tool":  e.Tool,
+		"time":  e.Time.Format(time.RFC3339),
+		"paths": paths,
+	}
+	if e.Irreversible != "" {
+		m["irreversible"] = e.Irreversible
+	}
+	return m
+}
+
+// journal holds a session's history. entries[:pos] can be undone and
+// entries[pos:] can be redone.
+type journal struct {
+	mu      sync.Mutex
+	entries []*journalEntry
+	pos     int
+	nextID  int
+	bytes   int64
+}
+
+var (
+	journalsMu sync.Mutex
+	journals   = map[string]*journal{}
+)
+
+func sessionJournal(session string) *journal {
+	if session == "" {
+		session = DefaultSession
+	}
+	journalsMu.Lock()
+	defer journalsMu.Unlock()
+	j, ok := journals[session]
+	if !ok {
+		j = &journal{nextID: 1}
+		journals[session] = j
+	}
+	return j
+}
+
+// DropJournal forgets the history of a session, e.g. when it disconnects.
+func DropJournal(session string) {
+	journalsMu.Lock()
+	defer journalsMu.Unlock()
+	delete(journals, session)
+}
+
+func snapshotAll(paths []string) ([]pathState, error) {
+	budget := int64(journalSnapshotLimit)
+	states := make([]pathState, 0, len(paths))
+	for _, p := range paths {
+		s, err := snapshotPath(p, &budget)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, s)
+	}
+	return states, nil
+}
+
+// Journaled runs fn, a mutation touching paths, and records it in the
+// session's journal so it can be undone. Paths that don't resolve inside the
+// allowed roots are left for fn to reject.
+func Journaled(session, tool string, paths []string, allowedDirs []string, fn func() (ToolResult, error)) (ToolResult, error) {
+	var absPaths []string
+	seen := map[string]bool{}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		absPath, err := findAllowedPath(allowedDirs, p, false)
+		if err != nil || seen[absPath] {
+			continue
+		}
+		seen[absPath] = true
+		absPaths = append(absPaths, absPath)
+	}
+	j := sessionJournal(session)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	before, beforeErr := snapshotAll(absPaths)
+	for _, p := range absPaths {
+		// Restoring a whole root would also wipe its trash.
+		if root, err := rootFor(allowedDirs, p); err == nil && root == p {
+			beforeErr = errors.New("operations on an allowed root are not journaled")
+		}
+	}
+	res, err := fn()
+	if err != nil || len(absPaths) == 0 {
+		return res, err
+	}
+	entry := &journalEntry{Tool: tool, Time: time.Now()}
+	if beforeErr != nil {
+		entry.Irreversible = beforeErr.Error()
+		for _, p := range absPaths {
+			entry.Before = append(entry.Before, pathState{Path: p})
+		}
+	} else {
+		after, afterErr := snapshotAll(absPaths)
+		if afterErr != nil {
+			entry.Irreversible = afterErr.Error()
+		}
+		changed := afterErr != nil
+		for i := range before {
+			if afterErr == nil && before[i].touched(after[i]) {
+				changed = true
+			}
+		}
+		if !changed {
+			return res, nil
+		}
+		entry.Before, entry.After = before, after
+		entry.Trashed = trashedPaths(res, allowedDirs)
+	}
+	entry.ID = j.nextID
+	j.nextID++
+	for _, s := range append(entry.Before, entry.After...) {
+		entry.size += s.size()
+	}
+	j.add(entry)
+	return res, nil
+}
+
+// trashedPaths maps the original paths of the trash entries a delete_file
+// or batch result reports to their IDs.
+func trashedPaths(res ToolResult, allowedDirs []string) map[string]string {
+	ids := []interface{}{res["trashId"]}
+	if ops, ok := res["operations"].([]map[string]interface{}); ok {
+		for _, op := range ops {
+			if r, ok := op["result"].(ToolResult); ok {
+				ids = append(ids, r["trashId"])
+			}
+		}
+	}
+	trashed := map[string]string{}
+	for _, v := range ids {
+		id, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if entry, err := findTrashEntry(allowedDirs, id); err == nil {
+			trashed[entry.OriginalPath] = id
+		}
+	}
+	if len(trashed) == 0 {
+		return nil
+	}
+	return trashed
+}
+
+// add appends entry after the current position, dropping any redo history,
+// then forgets the oldest entries beyond journalMaxEntries or
+// journalSessionLimit.
+func (j *journal) add(entry *journalEntry) {
+	for _, dropped := range j.entries[j.pos:] {
+		j.bytes -= dropped.size
+	}
+	j.entries = append(j.entries[:j.pos], entry)
+	j.bytes += entry.size
+	for len(j.entries) > 1 && (len(j.entries) > journalMaxEntries || j.bytes > journalSessionLimit) {
+		j.bytes -= j.entries[0].size
+		j.entries[0] = nil
+		j.entries = j.entries[1:]
+	}
+	j.pos = len(j.entries)
+}
+
+// conflicts lists the paths whose current state differs from want.
+func conflicts(want []pathState) ([]map[string]interface{}, error) {
+	current, err := snapshotAll(pathsOf(want))
+	if err != nil {
+		return nil, err
+	}
+	var report []map[string]interface{}
+	for i := range want {
+		if !want[i].equal(current[i]) {
+			report = append(report, map[string]interface{}{
+				"path":   want[i].Path,
+				"reason": "changed outside the journal since the operation",
+			})
+		}
+	}
+	return report, nil
+}
+
+func pathsOf(states []pathState) []string {
+	paths := make([]string, len(states))
+	for i, s := range states {
+		paths[i] = s.Path
+	}
+	return paths
+}
+
+// step moves one entry backwards (undo) or forwards (redo), refusing if the
+// files no longer match the state the entry expects.
+func (j *journal) step(undo bool, allowedDirs []string) (ToolResult, error) {
+	var entry *journalEntry
+	if undo {
+		if j.pos == 0 {
+			return nil, errors.New("nothing to undo")
+		}
+		entry = j.entries[j.pos-1]
+	} else {
+		if j.pos == len(j.entries) {
+			return nil, errors.New("nothing to redo")
+		}
+		entry = j.entries[j.pos]
+	}
+	if entry.Irreversible != "" {
+		return nil, fmt.Errorf("%s #%d cannot be reversed: %s", entry.Tool, entry.ID, entry.Irreversible)
+	}
+	expected, target := entry.After, entry.Before
+	if !undo {
+		expected, target = entry.Before, entry.After
+	}
+	for _, s := range target {
+		if _, err := rootFor(allowedDirs, s.Path); err != nil {
+			return nil, err
+		}
+		if err := checkWritable(allowedDirs, s.Path, false); err != nil {
+			return nil, err
+		}
+	}
+	report, err := conflicts(expected)
+	if err != nil {
+		return nil, err
+	}
+	if len(report) > 0 {
+		return ToolResult{
+			"ok":        false,
+			"entry":     entry.summary(),
+			"conflicts": report,
+			"isError":   true,
+		}, nil
+	}
+	// Redoing a delete moves the path to the trash again rather than
+	// removing it, so the item stays recoverable.
+	if !undo {
+		for path := range entry.Trashed {
+			root, err := rootFor(allowedDirs, path)
+			if err != nil {
+				return nil, err
+			}
+			trashed, err := moveToTrash(root, path)
+			if err != nil {
+				return nil, err
+			}
+			entry.Trashed[path] = trashed.ID
+		}
+	}
+	// Parents go first so nested paths are restored after their ancestors.
+	order := make([]pathState, len(target))
+	copy(order, target)
+	sort.SliceStable(order, func(a, b int) bool { return len(order[a].Path) < len(order[b].Path) })
+	for _, s := range order {
+		if err := s.restore(); err != nil {
+			return nil, err
+		}
+	}
+	// An undone delete is back in place, so its trash entry is dropped
+	// rather than left as a second copy.
+	if undo {
+		for _, id := range entry.Trashed {
+			if trashed, err := findTrashEntry(allowedDirs, id); err == nil {
+				os.RemoveAll(filepath.Join(trashDir(trashed.Root), id))
+			}
+		}
+	}
+	if undo {
+		j.pos--
+	} else {
+		j.pos++
+	}
+	return ToolResult{"ok": true, "entry": entry.summary()}, nil
+}
+
+type UndoParams struct {
+	Steps int `json:"steps" description:"Number of operations to undo" default:"1"`
+}
+
+type RedoParams struct {
+	Steps int `json:"steps" description:"Number of operations to redo" default:"1"`
+}
+
+func walkJournal(session string, steps int, undo bool, allowedDirs []string) (ToolResult, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	j := sessionJournal(session)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var done []interface{}
+	for i := 0; i < steps; i++ {
+		res, err := j.step(undo, allowedDirs)
+		if err != nil {
+			if len(done) > 0 {
+				return ToolResult{"ok": true, "entries": done, "stopped": err.Error()}, nil
+			}
+			return nil, err
+		}
+		if ok, _ := res["ok"].(bool); !ok {
+			res["entries"] = done
+			return res, nil
+		}
+		done = append(done, res["entry"])
+	}
+	return ToolResult{"ok": true, "entries": done}, nil
+}
+
+// Undo reverts the session's most recent operations, newest first.
+func Undo(params UndoParams, session string, allowedDirs []string) (ToolResult, error) {
+	return walkJournal(session, params.Steps, true, allowedDirs)
+}
+
+// Redo reapplies operations previously reverted with Undo.
+func Redo(params RedoParams, session string, allowedDirs []string) (ToolResult, error) {
+	return walkJournal(session, params.Steps, false, allowedDirs)
+}
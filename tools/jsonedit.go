@@ -0,0 +1,732 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// JSON documents are edited as a tree that keeps the order of object keys.
+// Values are nil, bool, json.Number, string, *jsonObject or *jsonArray.
+// Objects and arrays that were written on a single line are marked inline so
+// they are written back that way.
+
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+	inline bool
+}
+
+func (o *jsonObject) set(key string, v interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+func (o *jsonObject) remove(key string) {
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+type jsonArray struct {
+	items  []interface{}
+	inline bool
+}
+
+// parseJSON parses a single JSON value. Syntax errors carry their line and
+// column.
+func parseJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeJSONValue(dec, data)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return v, nil
+		} else if err == nil {
+			err = errors.New("unexpected data after the top-level value")
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errors.New("unexpected end of JSON input")
+	}
+	offset := dec.InputOffset()
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		offset = syntax.Offset
+	}
+	line, col := textPosition(data, offset)
+	return nil, &syntaxError{Language: "JSON", Line: line, Column: col, Message: err.Error()}
+}
+
+// textPosition converts a byte offset to a 1-based line and column.
+func textPosition(data []byte, offset int64) (int, int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+func decodeJSONValue(dec *json.Decoder, data []byte) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	start := dec.InputOffset()
+	inline := func() bool {
+		return !bytes.ContainsRune(data[start:dec.InputOffset()], '\n')
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &jsonObject{values: map[string]interface{}{}}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSONValue(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			obj.set(key.(string), v)
+		}
+		_, err := dec.Token()
+		obj.inline = inline()
+		return obj, err
+	case json.Delim('['):
+		arr := &jsonArray{}
+		for dec.More() {
+			v, err := decodeJSONValue(dec, data)
+			if err != nil {
+				return nil, err
+			}
+			arr.items = append(arr.items, v)
+		}
+		_, err := dec.Token()
+		arr.inline = inline()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// jsonFormat is the layout used when writing a document back. An empty
+// indent writes compact JSON; inline marks a single-line part of an
+// indented document.
+type jsonFormat struct {
+	indent string
+	inline bool
+}
+
+// detectJSONFormat takes the indentation of the first indented line.
+// Single-line documents stay compact.
+func detectJSONFormat(text string) jsonFormat {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if len(lines) < 2 {
+		return jsonFormat{}
+	}
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return jsonFormat{indent: line[:len(line)-len(trimmed)]}
+		}
+	}
+	return jsonFormat{indent: "  "}
+}
+
+func (f jsonFormat) encode(v interface{}) string {
+	var buf bytes.Buffer
+	f.write(&buf, v, 0)
+	return buf.String()
+}
+
+func (f jsonFormat) newline(buf *bytes.Buffer, depth int) {
+	if f.indent == "" || f.inline {
+		return
+	}
+	buf.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		buf.WriteString(f.indent)
+	}
+}
+
+// separator writes the comma between members, followed by a space on
+// single-line parts of an indented document.
+func (f jsonFormat) separator(buf *bytes.Buffer) {
+	buf.WriteByte(',')
+	if f.indent != "" && f.inline {
+		buf.WriteByte(' ')
+	}
+}
+
+func (f jsonFormat) write(buf *bytes.Buffer, v interface{}, depth int) {
+	switch v := v.(type) {
+	case *jsonObject:
+		if len(v.keys) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		f.inline = f.inline || v.inline
+		buf.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				f.separator(buf)
+			}
+			f.newline(buf, depth+1)
+			buf.WriteString(jsonString(k))
+			buf.WriteByte(':')
+			if f.indent != "" {
+				buf.WriteByte(' ')
+			}
+			f.write(buf, v.values[k], depth+1)
+		}
+		f.newline(buf, depth)
+		buf.WriteByte('}')
+	case *jsonArray:
+		if len(v.items) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		f.inline = f.inline || v.inline
+		buf.WriteByte('[')
+		for i, item := range v.items {
+			if i > 0 {
+				f.separator(buf)
+			}
+			f.newline(buf, depth+1)
+			f.write(buf, item, depth+1)
+		}
+		f.newline(buf, depth)
+		buf.WriteByte(']')
+	case string:
+		buf.WriteString(jsonString(v))
+	case json.Number:
+		buf.WriteString(string(v))
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case nil:
+		buf.WriteString("null")
+	}
+}
+
+// jsonString quotes s without escaping HTML characters.
+func jsonString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case *jsonObject:
+		return "object"
+	case *jsonArray:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
+
+func cloneJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case *jsonObject:
+		c := &jsonObject{keys: append([]string{}, v.keys...), values: map[string]interface{}{}, inline: v.inline}
+		for k, x := range v.values {
+			c.values[k] = cloneJSON(x)
+		}
+		return c
+	case *jsonArray:
+		c := &jsonArray{items: make([]interface{}, len(v.items)), inline: v.inline}
+		for i, x := range v.items {
+			c.items[i] = cloneJSON(x)
+		}
+		return c
+	}
+	return v
+}
+
+// expandJSON clears the inline marks of v so it is laid out like the rest of
+// the document it is added to.
+func expandJSON(v interface{}) {
+	switch v := v.(type) {
+	case *jsonObject:
+		v.inline = false
+		for _, x := range v.values {
+			expandJSON(x)
+		}
+	case *jsonArray:
+		v.inline = false
+		for _, x := range v.items {
+			expandJSON(x)
+		}
+	}
+}
+
+// equalJSON compares values as RFC 6902 test does: key order is ignored
+// and numbers are compared by value.
+func equalJSON(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *jsonObject:
+		o, ok := b.(*jsonObject)
+		if !ok || len(a.keys) != len(o.keys) {
+			return false
+		}
+		for k, x := range a.values {
+			y, ok := o.values[k]
+			if !ok || !equalJSON(x, y) {
+				return false
+			}
+		}
+		return true
+	case *jsonArray:
+		o, ok := b.(*jsonArray)
+		if !ok || len(a.items) != len(o.items) {
+			return false
+		}
+		for i := range a.items {
+			if !equalJSON(a.items[i], o.items[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		n, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := n.Float64()
+		if errA != nil || errB != nil {
+			return a == n
+		}
+		return x == y
+	}
+	return a == b
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens. The
+// empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if pointer[0] != '/' {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must be empty or start with /", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex resolves an array token. "-" means the end of the array and is
+// only accepted when allowEnd is set, as is an index equal to the length.
+func arrayIndex(arr *jsonArray, token string, allowEnd bool) (int, error) {
+	if token == "-" {
+		if allowEnd {
+			return len(arr.items), nil
+		}
+		return 0, errors.New(`"-" refers to a nonexistent array element`)
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	limit := len(arr.items)
+	if allowEnd {
+		limit++
+	}
+	if i >= limit {
+		return 0, fmt.Errorf("array index %d out of range (length %d)", i, len(arr.items))
+	}
+	return i, nil
+}
+
+func formatPointer(tokens []string) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		b.WriteByte('/')
+		b.WriteString(strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1"))
+	}
+	return b.String()
+}
+
+// lookupJSON returns the value at tokens.
+func lookupJSON(doc interface{}, tokens []string) (interface{}, error) {
+	v := doc
+	for i, t := range tokens {
+		switch c := v.(type) {
+		case *jsonObject:
+			x, ok := c.values[t]
+			if !ok {
+				return nil, fmt.Errorf("%s: key %q not found", formatPointer(tokens[:i]), t)
+			}
+			v = x
+		case *jsonArray:
+			idx, err := arrayIndex(c, t, false)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", formatPointer(tokens[:i]), err)
+			}
+			v = c.items[idx]
+		default:
+			return nil, fmt.Errorf("%s: cannot descend into a %s", formatPointer(tokens[:i]), jsonKind(v))
+		}
+	}
+	return v, nil
+}
+
+// addJSON implements the RFC 6902 add operation: it inserts into arrays and
+// adds or replaces object members. It returns the new document.
+func addJSON(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	parent, err := lookupJSON(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case *jsonObject:
+		p.set(last, value)
+	case *jsonArray:
+		i, err := arrayIndex(p, last, true)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", formatPointer(tokens[:len(tokens)-1]), err)
+		}
+		p.items = append(p.items, nil)
+		copy(p.items[i+1:], p.items[i:])
+		p.items[i] = value
+	default:
+		return nil, fmt.Errorf("%s: cannot add to a %s", formatPointer(tokens[:len(tokens)-1]), jsonKind(parent))
+	}
+	return doc, nil
+}
+
+// replaceJSON replaces an existing value and returns the new document.
+func replaceJSON(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	if _, err := lookupJSON(doc, tokens); err != nil {
+		return nil, err
+	}
+	parent, _ := lookupJSON(doc, tokens[:len(tokens)-1])
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case *jsonObject:
+		p.values[last] = value
+	case *jsonArray:
+		i, _ := arrayIndex(p, last, false)
+		p.items[i] = value
+	}
+	return doc, nil
+}
+
+// removeJSON removes an existing value and returns it.
+func removeJSON(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove the whole document")
+	}
+	v, err := lookupJSON(doc, tokens)
+	if err != nil {
+		return nil, err
+	}
+	parent, _ := lookupJSON(doc, tokens[:len(tokens)-1])
+	last := tokens[len(tokens)-1]
+	switch p := parent.(type) {
+	case *jsonObject:
+		p.remove(last)
+	case *jsonArray:
+		i, _ := arrayIndex(p, last, false)
+		p.items = append(p.items[:i], p.items[i+1:]...)
+	}
+	return v, nil
+}
+
+// setJSON replaces the value at tokens, or adds it when its parent exists.
+// With createParents, missing objects along the way are created.
+func setJSON(doc interface{}, tokens []string, value interface{}, createParents bool) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	if createParents {
+		v := doc
+		for _, t := range tokens[:len(tokens)-1] {
+			obj, ok := v.(*jsonObject)
+			if !ok {
+				break
+			}
+			if _, ok := obj.values[t]; !ok {
+				obj.set(t, &jsonObject{values: map[string]interface{}{}})
+			}
+			v = obj.values[t]
+		}
+	}
+	parent, err := lookupJSON(doc, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+	if arr, ok := parent.(*jsonArray); ok {
+		last := tokens[len(tokens)-1]
+		if last == "-" {
+			arr.items = append(arr.items, value)
+			return doc, nil
+		}
+		i, err := arrayIndex(arr, last, false)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", formatPointer(tokens[:len(tokens)-1]), err)
+		}
+		arr.items[i] = value
+		return doc, nil
+	}
+	return addJSON(doc, tokens, value)
+}
+
+// parseJSONValue parses a value passed as a tool argument.
+func parseJSONValue(name string, raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("%s is required", name)
+	}
+	v, err := parseJSON(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	expandJSON(v)
+	return v, nil
+}
+
+// readJSONFile reads and parses a JSON file within the allowed roots.
+func readJSONFile(path string, allowedDirs []string) (string, interface{}, []byte, error) {
+	absPath, err := findAllowedRoot(allowedDirs, path)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	doc, err := parseJSON(bytes.TrimPrefix(data, utf8BOM))
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return absPath, doc, data, nil
+}
+
+// updateJSONFile applies fn to a parsed JSON file and writes the document
+// back with the file's indentation, line endings, BOM and final newline.
+func updateJSONFile(path string, dryRun bool, allowedDirs []string, fn func(doc interface{}) (interface{}, error)) (ToolResult, error) {
+	absPath, doc, data, err := readJSONFile(path, allowedDirs)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWritable(allowedDirs, absPath, true); err != nil {
+		return nil, err
+	}
+	text, style := decodeText(data)
+	doc, err = fn(doc)
+	if err != nil {
+		return nil, err
+	}
+	content := detectJSONFormat(text).encode(doc)
+	if !json.Valid([]byte(content)) {
+		return nil, errors.New("edit produced invalid JSON; file left unchanged")
+	}
+	content = withFinalNewline(content, style.FinalNewline)
+	changed := content != text
+	oldName, newName := diffNames(allowedDirs, absPath)
+	result := ToolResult{
+		"ok":      true,
+		"changed": changed,
+		"diff":    unifiedDiff(oldName, newName, text, content, defaultContextLines),
+	}
+	if dryRun {
+		result["dryRun"] = true
+		return result, nil
+	}
+	if changed {
+		info, err := os.Stat(absPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(absPath, encodeText(content, style), info.Mode().Perm()); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+type JSONGetParams struct {
+	Path    string `json:"path" description:"JSON file" required:"true"`
+	Pointer string `json:"pointer" description:"JSON Pointer (RFC 6901) to the value; empty for the whole document"`
+}
+
+// JSONGet returns the value a JSON Pointer refers to.
+func JSONGet(params JSONGetParams, allowedDirs []string) (ToolResult, error) {
+	_, doc, _, err := readJSONFile(params.Path, allowedDirs)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := parsePointer(params.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	v, err := lookupJSON(doc, tokens)
+	if err != nil {
+		return nil, err
+	}
+	return ToolResult{
+		"value": json.RawMessage(jsonFormat{}.encode(v)),
+		"type":  jsonKind(v),
+	}, nil
+}
+
+type JSONSetParams struct {
+	Path          string          `json:"path" description:"JSON file" required:"true"`
+	Pointer       string          `json:"pointer" description:"JSON Pointer (RFC 6901) to set; /- appends to an array" required:"true"`
+	Value         json.RawMessage `json:"value" description:"New value, any JSON including null"`
+	CreateParents bool            `json:"createParents" description:"Create missing parent objects"`
+	DryRun        bool            `json:"dryRun" description:"Return a diff without writing"`
+}
+
+// JSONSet replaces or adds the value at a JSON Pointer.
+func JSONSet(params JSONSetParams, allowedDirs []string) (ToolResult, error) {
+	tokens, err := parsePointer(params.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseJSONValue("value", params.Value)
+	if err != nil {
+		return nil, err
+	}
+	return updateJSONFile(params.Path, params.DryRun, allowedDirs, func(doc interface{}) (interface{}, error) {
+		return setJSON(doc, tokens, value, params.CreateParents)
+	})
+}
+
+type JSONDeleteParams struct {
+	Path    string `json:"path" description:"JSON file" required:"true"`
+	Pointer string `json:"pointer" description:"JSON Pointer (RFC 6901) to remove" required:"true"`
+	DryRun  bool   `json:"dryRun" description:"Return a diff without writing"`
+}
+
+// JSONDelete removes the member or array element at a JSON Pointer.
+func JSONDelete(params JSONDeleteParams, allowedDirs []string) (ToolResult, error) {
+	tokens, err := parsePointer(params.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	return updateJSONFile(params.Path, params.DryRun, allowedDirs, func(doc interface{}) (interface{}, error) {
+		_, err := removeJSON(doc, tokens)
+		return doc, err
+	})
+}
+
+// JSONPatchOperation is one RFC 6902 operation.
+type JSONPatchOperation struct {
+	Op    string          `json:"op" description:"Operation" required:"true" enum:"add,remove,replace,move,copy,test"`
+	Path  string          `json:"path" description:"JSON Pointer the operation applies to" required:"true"`
+	From  string          `json:"from" description:"Source pointer for move and copy"`
+	Value json.RawMessage `json:"value" description:"Value for add, replace and test"`
+}
+
+type JSONPatchParams struct {
+	Path       string               `json:"path" description:"JSON file" required:"true"`
+	Operations []JSONPatchOperation `json:"operations" description:"RFC 6902 operations, applied in order" required:"true"`
+	DryRun     bool                 `json:"dryRun" description:"Return a diff without writing"`
+}
+
+func applyJSONPatchOperation(doc interface{}, op JSONPatchOperation) (interface{}, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	switch op.Op {
+	case "add", "replace", "test":
+		value, err := parseJSONValue("value", op.Value)
+		if err != nil {
+			return nil, err
+		}
+		switch op.Op {
+		case "add":
+			return addJSON(doc, tokens, value)
+		case "replace":
+			return replaceJSON(doc, tokens, value)
+		}
+		current, err := lookupJSON(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !equalJSON(current, value) {
+			return nil, fmt.Errorf("test failed: %s is %s", op.Path, jsonFormat{}.encode(current))
+		}
+		return doc, nil
+	case "remove":
+		_, err := removeJSON(doc, tokens)
+		return doc, err
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if op.From == op.Path {
+				return doc, nil
+			}
+			if strings.HasPrefix(op.Path, op.From+"/") {
+				return nil, errors.New("cannot move a value into one of its children")
+			}
+			v, err := removeJSON(doc, from)
+			if err != nil {
+				return nil, err
+			}
+			return addJSON(doc, tokens, v)
+		}
+		v, err := lookupJSON(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		return addJSON(doc, tokens, cloneJSON(v))
+	}
+	return nil, fmt.Errorf("unknown op %q (expected add, remove, replace, move, copy or test)", op.Op)
+}
+
+// JSONPatch applies RFC 6902 operations to a JSON file. They apply as a
+// unit: if one fails, the file is left unchanged.
+func JSONPatch(params JSONPatchParams, allowedDirs []string) (ToolResult, error) {
+	if len(params.Operations) == 0 {
+		return nil, errors.New("no operations given")
+	}
+	return updateJSONFile(params.Path, params.DryRun, allowedDirs, func(doc interface{}) (interface{}, error) {
+		var err error
+		for i, op := range params.Operations {
+			if doc, err = applyJSONPatchOperation(doc, op); err != nil {
+				return nil, fmt.Errorf("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+			}
+		}
+		return doc, nil
+	})
+}
@@ -0,0 +1,198 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Line-addressed edit operations accepted by EditOperation.Op. An empty Op
+// replaces OldText.
+const (
+	editInsert       = "insert"
+	editDelete       = "delete"
+	editReplace      = "replace"
+	editInsertBefore = "insertBefore"
+	editInsertAfter  = "insertAfter"
+)
+
+// contentHash is the hash reported by read_file and checked by edits that
+// address lines: the hex SHA-256 of the file's bytes.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkHash compares a file's hash with the one a caller expects. A
+// "sha256:" prefix and upper case are accepted.
+func checkHash(data []byte, expected string) error {
+	want := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(expected), "sha256:"))
+	if got := contentHash(data); got != want {
+		return fmt.Errorf("file has changed: expected hash %s, current hash is %s; read it again", want, got)
+	}
+	return nil
+}
+
+// mapRange translates lines start..end of the file as it was read to the
+// current text, through the hunks of each earlier edit. It fails if an
+// earlier edit changed any of those lines or inserted lines between them.
+func mapRange(history [][]editHunk, start, end int) (int, int, error) {
+	origStart, origEnd := start, end
+	for _, hunks := range history {
+		shift := 0
+		for _, h := range hunks {
+			oldEnd := h.OldStart + len(h.OldLines)
+			var before, after bool
+			if len(h.OldLines) == 0 {
+				before, after = h.OldStart <= start, h.OldStart > end
+			} else {
+				before, after = oldEnd <= start, h.OldStart > end
+			}
+			switch {
+			case before:
+				shift += len(h.NewLines) - len(h.OldLines)
+			case after:
+			default:
+				if origStart == origEnd {
+					return 0, 0, fmt.Errorf("line %d was changed by an earlier edit", origStart)
+				}
+				return 0, 0, fmt.Errorf("lines %d-%d were changed by an earlier edit", origStart, origEnd)
+			}
+		}
+		start += shift
+		end += shift
+	}
+	return start, end, nil
+}
+
+// lineSplice replaces Delete lines starting at index At with Insert.
+type lineSplice struct {
+	At, Delete int
+	Insert     []string
+}
+
+// spliceLines applies splices, sorted by At and not overlapping, and returns
+// one hunk per splice.
+func spliceLines(lines []string, splices []lineSplice) ([]string, []editHunk) {
+	var out []string
+	var hunks []editHunk
+	prev, delta := 0, 0
+	for _, s := range splices {
+		out = append(out, lines[prev:s.At]...)
+		out = append(out, s.Insert...)
+		hunks = append(hunks, editHunk{
+			OldStart: s.At + 1,
+			OldLines: lines[s.At : s.At+s.Delete],
+			NewStart: s.At + 1 + delta,
+			NewLines: s.Insert,
+		})
+		delta += len(s.Insert) - s.Delete
+		prev = s.At + s.Delete
+	}
+	out = append(out, lines[prev:]...)
+	return out, hunks
+}
+
+// applyLineEdit applies an insert, delete, replace, insertBefore or
+// insertAfter operation. Line numbers refer to the file as it was read;
+// patterns are matched against the current text.
+func applyLineEdit(content string, edit EditOperation, history [][]editHunk) (string, []editHunk, error) {
+	lines := splitLines(content)
+	finalNewline := content == "" || strings.HasSuffix(content, "\n")
+	if !finalNewline {
+		lines[len(lines)-1] += "\n"
+	}
+	var insert []string
+	if text := normalizeText([]byte(edit.NewText)); text != "" {
+		insert = splitLines(withFinalNewline(text, true))
+	}
+
+	var splices []lineSplice
+	switch edit.Op {
+	case editInsert:
+		if len(insert) == 0 {
+			return "", nil, errors.New("insert requires newText")
+		}
+		at := 0
+		if edit.Line != 0 {
+			line, _, err := mapRange(history, edit.Line, edit.Line)
+			if err != nil {
+				return "", nil, err
+			}
+			if edit.Line < 0 || line > len(lines) {
+				return "", nil, fmt.Errorf("line %d is out of range (file has %d lines)", edit.Line, len(lines))
+			}
+			at = line
+		}
+		splices = []lineSplice{{At: at, Insert: insert}}
+
+	case editDelete, editReplace:
+		if edit.Op == editReplace && len(insert) == 0 {
+			return "", nil, errors.New("replace requires newText; use delete to remove lines")
+		}
+		if edit.EndLine == 0 {
+			edit.EndLine = edit.StartLine
+		}
+		if edit.StartLine < 1 || edit.EndLine < edit.StartLine {
+			return "", nil, fmt.Errorf("invalid line range %d-%d", edit.StartLine, edit.EndLine)
+		}
+		start, end, err := mapRange(history, edit.StartLine, edit.EndLine)
+		if err != nil {
+			return "", nil, err
+		}
+		if end > len(lines) {
+			return "", nil, fmt.Errorf("line %d is out of range (file has %d lines)", edit.EndLine, len(lines))
+		}
+		if edit.Op == editDelete {
+			insert = nil
+		}
+		splices = []lineSplice{{At: start - 1, Delete: end - start + 1, Insert: insert}}
+
+	case editInsertBefore, editInsertAfter:
+		if len(insert) == 0 {
+			return "", nil, fmt.Errorf("%s requires newText", edit.Op)
+		}
+		if edit.Pattern == "" {
+			return "", nil, fmt.Errorf("%s requires pattern", edit.Op)
+		}
+		re, err := regexp.Compile(edit.Pattern)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid pattern: %v", err)
+		}
+		var candidates []textMatch
+		offset := 0
+		for _, l := range lines {
+			if re.MatchString(strings.TrimSuffix(l, "\n")) {
+				candidates = append(candidates, textMatch{Start: offset, End: offset + len(l)})
+			}
+			offset += len(l)
+		}
+		if len(candidates) == 0 {
+			return "", nil, fmt.Errorf("no line matches pattern %q", edit.Pattern)
+		}
+		matches, err := selectMatches(content, candidates, edit)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, m := range matches {
+			at := lineAt(content, m.Start) - 1
+			if edit.Op == editInsertAfter {
+				at++
+			}
+			splices = append(splices, lineSplice{At: at, Insert: insert})
+		}
+
+	default:
+		return "", nil, fmt.Errorf("unknown op %q (expected insert, delete, replace, insertBefore or insertAfter)", edit.Op)
+	}
+
+	lines, hunks := spliceLines(lines, splices)
+	updated := strings.Join(lines, "")
+	if !finalNewline {
+		updated = strings.TrimSuffix(updated, "\n")
+	}
+	return updated, hunks, nil
+}
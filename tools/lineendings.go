@@ -0,0 +1,159 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// textStyle describes how a text file is laid out on disk: its dominant line
+// ending, whether it starts with a UTF-8 byte order mark and whether it ends
+// with a newline.
+type textStyle struct {
+	CRLF         bool
+	BOM          bool
+	FinalNewline bool
+}
+
+func (st textStyle) lineEnding() string {
+	if st.CRLF {
+		return "crlf"
+	}
+	return "lf"
+}
+
+func (st textStyle) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"lineEnding":   st.lineEnding(),
+		"bom":          st.BOM,
+		"finalNewline": st.FinalNewline,
+	}
+}
+
+// detectTextStyle inspects raw file data. Files with mixed endings are
+// classified by whichever ending is more common; ties go to LF.
+func detectTextStyle(data []byte) textStyle {
+	st := textStyle{BOM: bytes.HasPrefix(data, utf8BOM)}
+	crlf := bytes.Count(data, []byte("\r\n"))
+	lf := bytes.Count(data, []byte("\n")) - crlf
+	st.CRLF = crlf > lf
+	st.FinalNewline = bytes.HasSuffix(data, []byte("\n"))
+	return st
+}
+
+// normalizeText strips the BOM and converts CRLF to LF.
+func normalizeText(data []byte) string {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	return strings.ReplaceAll(string(data), "\r\n", "\n")
+}
+
+// decodeText returns the normalized text of data together with its style.
+func decodeText(data []byte) (string, textStyle) {
+	return normalizeText(data), detectTextStyle(data)
+}
+
+// encodeText converts normalized text back to st's line ending and BOM.
+// The final newline is left as it is in text.
+func encodeText(text string, st textStyle) []byte {
+	if st.CRLF {
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	}
+	if st.BOM {
+		return append(append([]byte{}, utf8BOM...), text...)
+	}
+	return []byte(text)
+}
+
+// withFinalNewline adds or removes the trailing newline of normalized text.
+// Only the final newline is removed; blank lines before it are kept.
+func withFinalNewline(text string, want bool) string {
+	has := strings.HasSuffix(text, "\n")
+	switch {
+	case want && !has && text != "":
+		return text + "\n"
+	case !want && has:
+		return strings.TrimSuffix(text, "\n")
+	}
+	return text
+}
+
+type NormalizeLineEndingsParams struct {
+	Path         string `json:"path" description:"File path" required:"true"`
+	LineEnding   string `json:"lineEnding" description:"Target line ending" enum:"lf,crlf,keep" default:"keep"`
+	BOM          string `json:"bom" description:"Byte order mark handling" enum:"keep,add,remove" default:"keep"`
+	FinalNewline string `json:"finalNewline" description:"Final newline handling" enum:"keep,add,remove" default:"keep"`
+	DryRun       bool   `json:"dryRun" description:"Report the change without writing"`
+}
+
+func parseKeepAddRemove(name, value string) (string, error) {
+	switch value {
+	case "", "keep":
+		return "keep", nil
+	case "add", "remove":
+		return value, nil
+	}
+	return "", fmt.Errorf("invalid %s %q (expected keep, add or remove)", name, value)
+}
+
+// NormalizeLineEndings rewrites a file with the requested line ending, and
+// optionally adds or removes its BOM and final newline.
+func NormalizeLineEndings(params NormalizeLineEndingsParams, allowedDirs []string) (ToolResult, error) {
+	absPath, err := findWritableRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	bom, err := parseKeepAddRemove("bom", params.BOM)
+	if err != nil {
+		return nil, err
+	}
+	finalNewline, err := parseKeepAddRemove("finalNewline", params.FinalNewline)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("path is a directory")
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	text, before := decodeText(data)
+	after := before
+	switch params.LineEnding {
+	case "lf":
+		after.CRLF = false
+	case "crlf":
+		after.CRLF = true
+	case "", "keep":
+	default:
+		return nil, fmt.Errorf("invalid lineEnding %q (expected lf, crlf or keep)", params.LineEnding)
+	}
+	if bom != "keep" {
+		after.BOM = bom == "add"
+	}
+	if finalNewline != "keep" {
+		text = withFinalNewline(text, finalNewline == "add")
+	}
+	after.FinalNewline = strings.HasSuffix(text, "\n")
+	out := encodeText(text, after)
+	changed := !bytes.Equal(out, data)
+	if changed && !params.DryRun {
+		if err := os.WriteFile(absPath, out, info.Mode().Perm()); err != nil {
+			return nil, err
+		}
+	}
+	return ToolResult{
+		"ok":      true,
+		"changed": changed,
+		"before":  before.toMap(),
+		"after":   after.toMap(),
+	}, nil
+}
@@ -0,0 +1,172 @@
+package tools
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinkHops bounds symlink resolution, matching the Linux limit.
+const maxSymlinkHops = 40
+
+// resolveReal returns path with every symbolic link evaluated. Unlike
+// filepath.EvalSymlinks it also works for paths that don't exist yet and for
+// dangling links, by resolving as far as the filesystem allows and appending
+// the remaining elements.
+func resolveReal(path string, hops int) (string, error) {
+	path = filepath.Clean(path)
+	root := filepath.VolumeName(path) + string(filepath.Separator)
+	return resolveFrom(root, strings.TrimPrefix(path, root), hops)
+}
+
+// resolveFrom resolves rel against dir, a path without links, one element
+// at a time as the kernel does: ".." applies to where the path has led so
+// far, so it does not cancel out a link that came before it.
+func resolveFrom(dir, rel string, hops int) (string, error) {
+	if hops > maxSymlinkHops {
+		return "", errors.New("too many levels of symbolic links")
+	}
+	cur := dir
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			cur = filepath.Dir(cur)
+			continue
+		}
+		candidate := filepath.Join(cur, elem)
+		info, err := os.Lstat(candidate)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			cur = candidate
+			continue
+		}
+		target, err := os.Readlink(candidate)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			cur, err = resolveReal(target, hops+1)
+		} else {
+			cur, err = resolveFrom(cur, target, hops+1)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return cur, nil
+}
+
+// withinRealRoots reports whether the resolved path real lies inside one of
+// the allowed roots, after resolving the roots' own symlinks.
+func withinRealRoots(allowedDirs []string, real string) bool {
+	for _, root := range allowedDirs {
+		absRoot, err := filepath.Abs(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		realRoot, err := resolveReal(absRoot, 0)
+		if err != nil {
+			continue
+		}
+		if real == realRoot || strings.HasPrefix(real, realRoot+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateSymlinkParams struct {
+	Path   string `json:"path" description:"Link to create" required:"true"`
+	Target string `json:"target" description:"Path the link points to" required:"true"`
+}
+
+// CreateSymlink creates a symbolic link at Path pointing to Target. A relative
+// target is stored as given and, as the kernel does, interpreted relative to
+// the real location of the link's directory. The target must resolve inside
+// the allowed roots.
+func CreateSymlink(params CreateSymlinkParams, allowedDirs []string) (ToolResult, error) {
+	linkPath, err := findWritablePath(allowedDirs, params.Path, false)
+	if err != nil {
+		return nil, err
+	}
+	if params.Target == "" {
+		return nil, errors.New("target is required")
+	}
+	var real string
+	if filepath.IsAbs(params.Target) {
+		real, err = resolveReal(params.Target, 0)
+	} else {
+		var parent string
+		parent, err = resolveReal(filepath.Dir(linkPath), 0)
+		if err == nil {
+			real, err = resolveFrom(parent, params.Target, 0)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !withinRealRoots(allowedDirs, real) {
+		return nil, errors.New("link target is outside of allowed directories")
+	}
+	if err := os.Symlink(params.Target, linkPath); err != nil {
+		return nil, err
+	}
+	_, statErr := os.Stat(linkPath)
+	return ToolResult{"ok": true, "resolved": real, "targetExists": statErr == nil}, nil
+}
+
+type CreateHardlinkParams struct {
+	Path   string `json:"path" description:"Link to create" required:"true"`
+	Target string `json:"target" description:"Existing file" required:"true"`
+}
+
+// CreateHardlink creates Path as another name for the existing file Target.
+func CreateHardlink(params CreateHardlinkParams, allowedDirs []string) (ToolResult, error) {
+	linkPath, err := findWritablePath(allowedDirs, params.Path, false)
+	if err != nil {
+		return nil, err
+	}
+	// The link is a second name for the target's content, so the target
+	// must be writable too.
+	target, err := findWritableRoot(allowedDirs, params.Target)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, errors.New("cannot hard link a directory")
+	}
+	if err := os.Link(target, linkPath); err != nil {
+		return nil, err
+	}
+	return ToolResult{"ok": true}, nil
+}
+
+type ReadLinkParams struct {
+	Path string `json:"path" description:"Symbolic link" required:"true"`
+}
+
+// ReadLink returns the target stored in a symbolic link and where it resolves.
+func ReadLink(params ReadLinkParams, allowedDirs []string) (ToolResult, error) {
+	linkPath, err := findAllowedPath(allowedDirs, params.Path, false)
+	if err != nil {
+		return nil, err
+	}
+	target, err := os.Readlink(linkPath)
+	if err != nil {
+		return nil, err
+	}
+	result := ToolResult{"target": target}
+	if real, err := resolveReal(linkPath, 0); err == nil {
+		result["resolved"] = real
+		result["withinAllowed"] = withinRealRoots(allowedDirs, real)
+		_, statErr := os.Stat(real)
+		result["targetExists"] = statErr == nil
+	}
+	return result, nil
+}
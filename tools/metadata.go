@@ -0,0 +1,345 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// modeChange turns a file's current mode into the requested one.
+type modeChange func(mode fs.FileMode, isDir bool) fs.FileMode
+
+const specialBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// parseModeChange accepts an octal mode such as "755" or "0644", or a
+// chmod(1)-style symbolic list such as "u+x,go-w" or "a=rX".
+func parseModeChange(spec string) (modeChange, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, errors.New("mode is required")
+	}
+	if n, err := strconv.ParseUint(spec, 8, 32); err == nil {
+		if n > 07777 {
+			return nil, fmt.Errorf("invalid octal mode %q", spec)
+		}
+		target := fs.FileMode(n & 0777)
+		if n&04000 != 0 {
+			target |= os.ModeSetuid
+		}
+		if n&02000 != 0 {
+			target |= os.ModeSetgid
+		}
+		if n&01000 != 0 {
+			target |= os.ModeSticky
+		}
+		return func(mode fs.FileMode, isDir bool) fs.FileMode {
+			return target
+		}, nil
+	}
+	var clauses []modeChange
+	for _, clause := range strings.Split(spec, ",") {
+		c, err := parseSymbolicClause(clause)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, c)
+	}
+	return func(mode fs.FileMode, isDir bool) fs.FileMode {
+		for _, c := range clauses {
+			mode = c(mode, isDir)
+		}
+		return mode
+	}, nil
+}
+
+// octalMode formats the permission and special bits of mode as chmod(1)
+// octal, e.g. 4755.
+func octalMode(mode fs.FileMode) string {
+	return fmt.Sprintf("%04o", chmodBits(mode))
+}
+
+// chmodBits returns the permission and special bits of mode laid out as in
+// chmod(1): 04000 for setuid, 02000 for setgid and 01000 for sticky.
+func chmodBits(mode fs.FileMode) fs.FileMode {
+	bits := mode.Perm()
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+func parseSymbolicClause(clause string) (modeChange, error) {
+	i := 0
+	var who fs.FileMode
+	for i < len(clause) && strings.ContainsRune("ugoa", rune(clause[i])) {
+		switch clause[i] {
+		case 'u':
+			who |= 0700
+		case 'g':
+			who |= 0070
+		case 'o':
+			who |= 0007
+		case 'a':
+			who |= 0777
+		}
+		i++
+	}
+	if who == 0 {
+		who = 0777
+	}
+	if i >= len(clause) || !strings.ContainsRune("+-=", rune(clause[i])) {
+		return nil, fmt.Errorf("invalid symbolic mode %q", clause)
+	}
+	op := clause[i]
+	perms := clause[i+1:]
+	for _, r := range perms {
+		if !strings.ContainsRune("rwxXst", r) {
+			return nil, fmt.Errorf("invalid permission %q in %q", r, clause)
+		}
+	}
+	return func(mode fs.FileMode, isDir bool) fs.FileMode {
+		var bits, special fs.FileMode
+		for _, r := range perms {
+			switch r {
+			case 'r':
+				bits |= 0444
+			case 'w':
+				bits |= 0222
+			case 'x':
+				bits |= 0111
+			case 'X':
+				if isDir || mode&0111 != 0 {
+					bits |= 0111
+				}
+			case 's':
+				if who&0700 != 0 {
+					special |= os.ModeSetuid
+				}
+				if who&0070 != 0 {
+					special |= os.ModeSetgid
+				}
+			case 't':
+				special |= os.ModeSticky
+			}
+		}
+		bits &= who
+		switch op {
+		case '+':
+			mode |= bits | special
+		case '-':
+			mode &^= bits | special
+		case '=':
+			// Assigning also clears the set-id and sticky bits of who.
+			var cleared fs.FileMode
+			if who&0700 != 0 {
+				cleared |= os.ModeSetuid
+			}
+			if who&0070 != 0 {
+				cleared |= os.ModeSetgid
+			}
+			if who&0007 != 0 {
+				cleared |= os.ModeSticky
+			}
+			mode = mode&^(who|cleared) | bits | special
+		}
+		return mode
+	}, nil
+}
+
+// walkTarget calls fn for absPath, and for everything below it when
+// recursive is set. Symbolic links are never followed and the trash is
+// skipped.
+func walkTarget(absPath string, recursive bool, fn func(path string, info fs.FileInfo) error) (int, error) {
+	count := 0
+	if !recursive {
+		info, err := os.Lstat(absPath)
+		if err != nil {
+			return 0, err
+		}
+		return 1, fn(absPath, info)
+	}
+	err := filepath.WalkDir(absPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && path != absPath && d.Name() == trashDirName {
+			return filepath.SkipDir
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		return fn(path, info)
+	})
+	return count, err
+}
+
+type SetPermissionsParams struct {
+	Path      string `json:"path" description:"Path" required:"true"`
+	Mode      string `json:"mode" description:"Octal or symbolic mode" required:"true"`
+	Recursive bool   `json:"recursive" description:"Apply to all entries below a directory"`
+}
+
+func SetPermissions(params SetPermissionsParams, allowedDirs []string) (ToolResult, error) {
+	absPath, err := findWritableRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	change, err := parseModeChange(params.Mode)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(absPath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return nil, errors.New("cannot change the permissions of a symbolic link")
+	}
+	// Symbolic links below a directory are skipped and not counted.
+	count := 0
+	_, err = walkTarget(absPath, params.Recursive, func(path string, info fs.FileInfo) error {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		count++
+		current := info.Mode() & (fs.ModePerm | specialBits)
+		return os.Chmod(path, change(current, info.IsDir()))
+	})
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	return ToolResult{
+		"ok":          true,
+		"entries":     count,
+		"mode":        info.Mode().String(),
+		"permissions": octalMode(info.Mode()),
+	}, nil
+}
+
+type SetOwnerParams struct {
+	Path      string `json:"path" description:"Path" required:"true"`
+	User      string `json:"user" description:"User name or uid"`
+	Group     string `json:"group" description:"Group name or gid"`
+	Recursive bool   `json:"recursive" description:"Apply to all entries below a directory"`
+}
+
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	s, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+// SetOwner changes the owning user and/or group, by name or numeric id. It is
+// only available when the policy allows it.
+func SetOwner(params SetOwnerParams, allowedDirs []string) (ToolResult, error) {
+	if !CurrentPolicy().AllowChown {
+		return nil, errors.New("changing ownership is disabled by policy")
+	}
+	absPath, err := findWritablePath(allowedDirs, params.Path, false)
+	if err != nil {
+		return nil, err
+	}
+	if params.User == "" && params.Group == "" {
+		return nil, errors.New("user or group is required")
+	}
+	uid, err := lookupID(params.User, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	gid, err := lookupID(params.Group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	count, err := walkTarget(absPath, params.Recursive, func(path string, info fs.FileInfo) error {
+		return os.Lchown(path, uid, gid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ToolResult{"ok": true, "entries": count}, nil
+}
+
+type SetTimesParams struct {
+	Path  string `json:"path" description:"Path" required:"true"`
+	Atime string `json:"atime" description:"Access time (RFC 3339 or \"now\")"`
+	Mtime string `json:"mtime" description:"Modification time (RFC 3339 or \"now\")"`
+}
+
+// parseTimestamp accepts RFC 3339 timestamps and "now". An empty value
+// yields the zero time, which leaves the timestamp unchanged.
+func parseTimestamp(name, value string, now time.Time) (time.Time, error) {
+	switch value {
+	case "":
+		return time.Time{}, nil
+	case "now":
+		return now, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// SetTimes sets the access and/or modification time of a path, like touch(1).
+// A missing file is not created.
+func SetTimes(params SetTimesParams, allowedDirs []string) (ToolResult, error) {
+	absPath, err := findWritableRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if params.Atime == "" && params.Mtime == "" {
+		params.Atime, params.Mtime = "now", "now"
+	}
+	atime, err := parseTimestamp("atime", params.Atime, now)
+	if err != nil {
+		return nil, err
+	}
+	mtime, err := parseTimestamp("mtime", params.Mtime, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chtimes(absPath, atime, mtime); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	return ToolResult{"ok": true, "modTime": info.ModTime().Format("2006-01-02T15:04:05Z07:00")}, nil
+}
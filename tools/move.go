@@ -0,0 +1,154 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var errDestinationExists = errors.New("destination already exists")
+
+// renameIfAbsent is the portable fallback for renameNoReplace. It leaves a
+// small window between the check and the rename.
+func renameIfAbsent(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return errDestinationExists
+	}
+	return os.Rename(src, dst)
+}
+
+func isCrossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// moveEntry renames src to dst and reports how the move was done. Unless
+// overwrite is set, an existing destination is never replaced. When src and
+// dst are on different filesystems the entry is copied and the source removed.
+func moveEntry(src, dst string, overwrite bool) (string, error) {
+	var err error
+	if overwrite {
+		err = os.Rename(src, dst)
+	} else {
+		err = renameNoReplace(src, dst)
+	}
+	if err == nil {
+		return "rename", nil
+	}
+	if !isCrossDevice(err) {
+		return "", err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return "", err
+	}
+	_, statErr := os.Lstat(dst)
+	existed := statErr == nil
+	if existed && !overwrite {
+		return "", errDestinationExists
+	}
+	if existed && info.IsDir() {
+		// Replacing a directory across devices can't be undone halfway.
+		return "", fmt.Errorf("cannot replace existing directory across filesystems: %s", dst)
+	}
+	// Copies are staged next to dst and renamed into place, so a failed copy
+	// leaves dst untouched and an entry created meanwhile is not replaced.
+	var stats copyStats
+	if info.IsDir() {
+		tmp, err := tempSibling(dst)
+		if err != nil {
+			return "", err
+		}
+		if err := copyTree(src, tmp, overwriteFail, false, &stats); err != nil {
+			os.RemoveAll(tmp)
+			return "", err
+		}
+		if err := commitCopy(tmp, dst, false); err != nil {
+			return "", err
+		}
+	} else {
+		policy := overwriteFail
+		if overwrite {
+			policy = overwriteReplace
+		}
+		if err := copyEntry(src, dst, info, policy, false, &stats); err != nil {
+			return "", err
+		}
+	}
+	if err := os.RemoveAll(src); err != nil {
+		return "", err
+	}
+	return "copy", nil
+}
+
+type MoveItem struct {
+	Source      string `json:"source" description:"Source path" required:"true"`
+	Destination string `json:"destination" description:"Destination path" required:"true"`
+	Overwrite   bool   `json:"overwrite" description:"Replace the destination if it exists"`
+}
+
+type MoveFileParams struct {
+	Source      string     `json:"source" description:"Source path"`
+	Destination string     `json:"destination" description:"Destination path"`
+	Overwrite   bool       `json:"overwrite" description:"Replace the destination if it exists"`
+	Items       []MoveItem `json:"items" description:"Batch of moves, instead of source and destination"`
+}
+
+func MoveFile(params MoveFileParams, allowedDirs []string) (ToolResult, error) {
+	if len(params.Items) > 0 {
+		if params.Source != "" || params.Destination != "" {
+			return nil, errors.New("use either source/destination or items, not both")
+		}
+		return moveBatch(params.Items, allowedDirs)
+	}
+	src, err := findWritablePath(allowedDirs, params.Source, false)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := findWritablePath(allowedDirs, params.Destination, false)
+	if err != nil {
+		return nil, err
+	}
+	method, err := moveEntry(src, dst, params.Overwrite)
+	if err != nil {
+		return nil, err
+	}
+	return ToolResult{"ok": true, "method": method}, nil
+}
+
+// moveBatch checks every item against the allowed roots before moving
+// anything, then moves them in order and reports each outcome.
+func moveBatch(items []MoveItem, allowedDirs []string) (ToolResult, error) {
+	type resolved struct{ src, dst string }
+	paths := make([]resolved, len(items))
+	for i, item := range items {
+		src, err := findWritablePath(allowedDirs, item.Source, false)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", i, err)
+		}
+		dst, err := findWritablePath(allowedDirs, item.Destination, false)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", i, err)
+		}
+		paths[i] = resolved{src, dst}
+	}
+	allOK := true
+	results := make([]map[string]interface{}, 0, len(items))
+	for i, item := range items {
+		r := map[string]interface{}{
+			"source":      item.Source,
+			"destination": item.Destination,
+		}
+		method, err := moveEntry(paths[i].src, paths[i].dst, item.Overwrite)
+		if err != nil {
+			allOK = false
+			r["ok"] = false
+			r["error"] = err.Error()
+		} else {
+			r["ok"] = true
+			r["method"] = method
+		}
+		results = append(results, r)
+	}
+	return ToolResult{"ok": allOK, "results": results}, nil
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package tools
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// renameNoReplace atomically renames src to dst, failing if dst exists. It
+// falls back to renameIfAbsent on kernels or filesystems without renameat2.
+func renameNoReplace(src, dst string) error {
+	err := unix.Renameat2(unix.AT_FDCWD, src, unix.AT_FDCWD, dst, unix.RENAME_NOREPLACE)
+	switch err {
+	case nil:
+		return nil
+	case unix.EEXIST:
+		return errDestinationExists
+	case unix.ENOSYS, unix.EINVAL:
+		return renameIfAbsent(src, dst)
+	}
+	return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+}
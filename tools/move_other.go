@@ -0,0 +1,7 @@
+//go:build !linux
+
+package tools
+
+func renameNoReplace(src, dst string) error {
+	return renameIfAbsent(src, dst)
+}
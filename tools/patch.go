@@ -0,0 +1,572 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// defaultPatchFuzz is how many context lines a hunk may drop from each end
+// when it doesn't match as given, as with patch(1)'s default fuzz factor.
+const defaultPatchFuzz = 2
+
+// patchSearchWindow limits how far from its stated line a hunk is looked for.
+const patchSearchWindow = 1000
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// patchHunk is one @@ block. Lines keep their "\n" unless the patch marks
+// them with "\ No newline at end of file".
+type patchHunk struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+	Lines              []diffOp
+}
+
+// filePatch is the part of a patch that concerns one file. An empty OldPath
+// means the file is created, an empty NewPath that it is deleted. Only git's
+// rename headers make a rename; other sections whose names differ patch one
+// file, chosen by resolvePatchTargets.
+type filePatch struct {
+	OldPath, NewPath string
+	Rename           bool
+	Hunks            []patchHunk
+}
+
+func (fp *filePatch) operation() string {
+	switch {
+	case fp.OldPath == "":
+		return "create"
+	case fp.NewPath == "":
+		return "delete"
+	case fp.Rename:
+		return "rename"
+	}
+	return "modify"
+}
+
+// path is the path the file has after the patch, or before it for deletes.
+func (fp *filePatch) path() string {
+	if fp.NewPath != "" {
+		return fp.NewPath
+	}
+	return fp.OldPath
+}
+
+// patchName parses a ---/+++ name, dropping a trailing timestamp and mapping
+// /dev/null to "".
+func patchName(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if unq, err := strconv.Unquote(s); err == nil && strings.HasPrefix(s, `"`) {
+		s = unq
+	}
+	return s
+}
+
+// stripGitPrefix removes the a/ and b/ prefixes git puts on paths, but only
+// when both names carry them (or one side is /dev/null).
+func stripGitPrefix(fp *filePatch) {
+	oldOK := fp.OldPath == "" || strings.HasPrefix(fp.OldPath, "a/")
+	newOK := fp.NewPath == "" || strings.HasPrefix(fp.NewPath, "b/")
+	if oldOK && newOK {
+		fp.OldPath = strings.TrimPrefix(fp.OldPath, "a/")
+		fp.NewPath = strings.TrimPrefix(fp.NewPath, "b/")
+	}
+}
+
+func atoiDefault(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// parsePatch reads a unified diff that may cover several files. Git extended
+// headers are understood for creations, deletions and renames, including
+// renames without hunks. Text outside file sections is ignored.
+func parsePatch(text string) ([]*filePatch, error) {
+	lines := strings.Split(normalizeText([]byte(text)), "\n")
+	var files []*filePatch
+	var cur *filePatch
+	gitHeader := false // cur came from "diff --git" and has no ---/+++ yet
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			names := strings.TrimPrefix(line, "diff --git ")
+			cur = &filePatch{}
+			if j := strings.Index(names, " b/"); j >= 0 {
+				cur.OldPath = strings.TrimPrefix(names[:j], "a/")
+				cur.NewPath = names[j+3:]
+			}
+			files = append(files, cur)
+			gitHeader = true
+
+		case gitHeader && strings.HasPrefix(line, "new file mode"):
+			cur.OldPath = ""
+		case gitHeader && strings.HasPrefix(line, "deleted file mode"):
+			cur.NewPath = ""
+		case gitHeader && strings.HasPrefix(line, "rename from "):
+			cur.OldPath = strings.TrimPrefix(line, "rename from ")
+			cur.Rename = true
+		case gitHeader && strings.HasPrefix(line, "rename to "):
+			cur.NewPath = strings.TrimPrefix(line, "rename to ")
+			cur.Rename = true
+		case gitHeader && (strings.HasPrefix(line, "copy from ") || strings.HasPrefix(line, "copy to ")):
+			return nil, errors.New("copy headers are not supported")
+
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if !gitHeader || cur == nil {
+				cur = &filePatch{}
+				files = append(files, cur)
+			}
+			cur.OldPath = patchName(line[4:])
+			cur.NewPath = patchName(lines[i+1][4:])
+			stripGitPrefix(cur)
+			gitHeader = false
+			i++
+
+		case strings.HasPrefix(line, "@@ "):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: hunk before any file header", i+1)
+			}
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("line %d: malformed hunk header %q", i+1, line)
+			}
+			h := patchHunk{
+				OldStart: atoiDefault(m[1], 0),
+				OldCount: atoiDefault(m[2], 1),
+				NewStart: atoiDefault(m[3], 0),
+				NewCount: atoiDefault(m[4], 1),
+			}
+			oldLeft, newLeft := h.OldCount, h.NewCount
+			for oldLeft > 0 || newLeft > 0 {
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("hunk %q is truncated", line)
+				}
+				body := lines[i]
+				kind := byte(' ')
+				if body != "" {
+					kind, body = body[0], body[1:]
+				}
+				switch kind {
+				case ' ':
+					oldLeft--
+					newLeft--
+				case '-':
+					oldLeft--
+				case '+':
+					newLeft--
+				case '\\':
+					trimLastNewline(h.Lines)
+					continue
+				default:
+					return nil, fmt.Errorf("line %d: unexpected %q in hunk", i+1, lines[i])
+				}
+				if oldLeft < 0 || newLeft < 0 {
+					return nil, fmt.Errorf("line %d: hunk longer than its header %q", i+1, line)
+				}
+				h.Lines = append(h.Lines, diffOp{kind, body + "\n"})
+			}
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], `\`) {
+				trimLastNewline(h.Lines)
+				i++
+			}
+			cur.Hunks = append(cur.Hunks, h)
+		}
+	}
+	for _, fp := range files {
+		if fp.OldPath == "" && fp.NewPath == "" {
+			return nil, errors.New("file section without a path")
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no file sections found in patch")
+	}
+	return files, nil
+}
+
+func trimLastNewline(ops []diffOp) {
+	if n := len(ops); n > 0 {
+		ops[n-1].Text = strings.TrimSuffix(ops[n-1].Text, "\n")
+	}
+}
+
+// hunkSides returns the lines a hunk expects and the lines it produces,
+// after dropping up to fuzz context lines from each end.
+func hunkSides(h patchHunk, fuzz int) (old, new []string, lead, trail int) {
+	ops := h.Lines
+	for lead < fuzz && lead < len(ops) && ops[lead].Kind == ' ' {
+		lead++
+	}
+	for trail < fuzz && trail < len(ops)-lead && ops[len(ops)-1-trail].Kind == ' ' {
+		trail++
+	}
+	for _, op := range ops[lead : len(ops)-trail] {
+		if op.Kind != '+' {
+			old = append(old, op.Text)
+		}
+		if op.Kind != '-' {
+			new = append(new, op.Text)
+		}
+	}
+	return old, new, lead, trail
+}
+
+func linesEqualAt(lines []string, at int, want []string) bool {
+	if at < 0 || at+len(want) > len(lines) {
+		return false
+	}
+	for i, l := range want {
+		if lines[at+i] != l {
+			return false
+		}
+	}
+	return true
+}
+
+// applyHunks applies hunks in order to lines. Each hunk is looked for at its
+// stated position adjusted by the drift of earlier hunks, then at growing
+// offsets, then again with fuzz. Hunks that can't be placed are rejected and
+// the rest still applied.
+func applyHunks(lines []string, hunks []patchHunk, maxFuzz int) ([]string, []map[string]interface{}, bool) {
+	var report []map[string]interface{}
+	allApplied := true
+	delta, minLine := 0, 0
+	for n, h := range hunks {
+		placed := false
+		dropped := -1
+		for fuzz := 0; fuzz <= maxFuzz && !placed; fuzz++ {
+			old, new, lead, trail := hunkSides(h, fuzz)
+			if lead+trail == dropped {
+				break // no more context to drop
+			}
+			dropped = lead + trail
+			orig := h.OldStart - 1 + lead
+			if h.OldCount == 0 {
+				orig = h.OldStart
+			}
+			expected := orig + delta
+			for off := 0; off <= patchSearchWindow && !placed; off++ {
+				candidates := []int{expected + off, expected - off}
+				if off == 0 {
+					candidates = candidates[:1]
+				}
+				for _, at := range candidates {
+					if at < minLine || at > len(lines) || !linesEqualAt(lines, at, old) {
+						continue
+					}
+					lines = append(lines[:at:at], append(append([]string{}, new...), lines[at+len(old):]...)...)
+					delta = at + len(new) - (orig + len(old))
+					minLine = at + len(new)
+					report = append(report, map[string]interface{}{
+						"hunk":   n,
+						"status": "applied",
+						"line":   at + 1,
+						"offset": at - expected,
+						"fuzz":   fuzz,
+					})
+					placed = true
+					break
+				}
+			}
+		}
+		if !placed {
+			allApplied = false
+			report = append(report, map[string]interface{}{
+				"hunk":   n,
+				"status": "rejected",
+				"line":   h.OldStart,
+				"reason": "context does not match",
+			})
+		}
+	}
+	return lines, report, allApplied
+}
+
+// PatchPaths returns the paths a patch touches, or nil if it can't be parsed.
+func PatchPaths(patch string) []string {
+	files, err := parsePatch(patch)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, fp := range files {
+		paths = append(paths, fp.OldPath, fp.NewPath)
+	}
+	return paths
+}
+
+// resolvePatchTargets picks the file each section patches when its names
+// differ without being a rename, as in "diff -u f.orig f": like patch(1), the
+// new name if that file exists, otherwise the old one.
+func resolvePatchTargets(files []*filePatch, allowedDirs []string) {
+	for _, fp := range files {
+		if fp.Rename || fp.OldPath == "" || fp.NewPath == "" || fp.OldPath == fp.NewPath {
+			continue
+		}
+		target := fp.NewPath
+		if !patchTargetExists(fp.NewPath, allowedDirs) && patchTargetExists(fp.OldPath, allowedDirs) {
+			target = fp.OldPath
+		}
+		fp.OldPath, fp.NewPath = target, target
+	}
+}
+
+func patchTargetExists(path string, allowedDirs []string) bool {
+	absPath, err := findAllowedRoot(allowedDirs, path)
+	if err != nil {
+		return false
+	}
+	_, err = os.Lstat(absPath)
+	return err == nil
+}
+
+type ApplyPatchParams struct {
+	Patch        string `json:"patch" description:"Unified diff text" required:"true"`
+	DryRun       bool   `json:"dryRun" description:"Check the patch without writing"`
+	AllowPartial bool   `json:"allowPartial" description:"Write the hunks that apply even if others are rejected"`
+	Fuzz         *int   `json:"fuzz" description:"Context lines that may be ignored at each end of a hunk" default:"2"`
+}
+
+// patchedFile is the outcome of applying the sections for one file in
+// memory. Sections after the first that modify the same file apply on top of
+// the earlier ones and add their reports.
+type patchedFile struct {
+	patch   *filePatch
+	content string
+	report  map[string]interface{}
+	reports []map[string]interface{}
+	ok      bool
+	applied int
+}
+
+// preparePatchedFile applies fp in memory, to base when an earlier section
+// already patched the file, or else to the file on disk.
+func preparePatchedFile(fp *filePatch, allowedDirs []string, maxFuzz int, base *string) patchedFile {
+	pf := patchedFile{patch: fp, report: map[string]interface{}{
+		"path":      fp.path(),
+		"operation": fp.operation(),
+	}}
+	pf.reports = []map[string]interface{}{pf.report}
+	if fp.Rename {
+		pf.report["from"] = fp.OldPath
+	}
+	fail := func(err error) patchedFile {
+		pf.report["status"] = "rejected"
+		pf.report["error"] = err.Error()
+		return pf
+	}
+	var text string
+	switch {
+	case base != nil:
+		text = *base
+	case fp.OldPath != "":
+		absPath, err := findAllowedRoot(allowedDirs, fp.OldPath)
+		if err != nil {
+			return fail(err)
+		}
+		data, err := os.ReadFile(absPath)
+		if err != nil {
+			return fail(err)
+		}
+		text = normalizeText(data)
+	}
+	if fp.NewPath != "" && fp.NewPath != fp.OldPath {
+		absPath, err := findAllowedRoot(allowedDirs, fp.NewPath)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err := os.Lstat(absPath); err == nil {
+			return fail(fmt.Errorf("%s already exists", fp.NewPath))
+		}
+	}
+	lines, hunks, ok := applyHunks(splitLines(text), fp.Hunks, maxFuzz)
+	pf.content = strings.Join(lines, "")
+	if fp.NewPath == "" && pf.content != "" && ok {
+		return fail(errors.New("file is not empty after removing the patch's lines"))
+	}
+	for _, h := range hunks {
+		if h["status"] == "applied" {
+			pf.applied++
+		}
+	}
+	pf.report["hunks"] = hunks
+	pf.ok = ok
+	if ok {
+		pf.report["status"] = "applied"
+	} else {
+		pf.report["status"] = "rejected"
+	}
+	return pf
+}
+
+// merge adds later, a further section for the same file, to pf.
+func (pf *patchedFile) merge(later patchedFile) {
+	pf.content = later.content
+	pf.ok = pf.ok && later.ok
+	pf.applied += later.applied
+	pf.reports = append(pf.reports, later.report)
+}
+
+// setStatus sets the status of every section of pf.
+func (pf *patchedFile) setStatus(status string) {
+	for _, r := range pf.reports {
+		r["status"] = status
+	}
+}
+
+// checkPatchedSyntax runs the syntax check on the final content of a file.
+// Under the blocking policy a failing file is not written, not even
+// partially.
+func (pf *patchedFile) checkPatchedSyntax() {
+	if pf.patch.NewPath == "" || pf.applied == 0 {
+		return
+	}
+	serr := checkSyntax(pf.patch.NewPath, []byte(pf.content))
+	if serr == nil {
+		return
+	}
+	if CurrentPolicy().SyntaxCheckWarnOnly {
+		pf.report["syntaxWarning"] = serr.toMap()
+		return
+	}
+	pf.report["syntaxError"] = serr.toMap()
+	pf.setStatus("rejected")
+	pf.ok, pf.applied = false, 0
+}
+
+// patchOperations turns the prepared files into batch operations.
+func patchOperations(files []*patchedFile, allowedDirs []string) ([]BatchOperation, error) {
+	var ops []BatchOperation
+	for _, pf := range files {
+		fp := pf.patch
+		if fp.NewPath != "" && fp.NewPath != fp.OldPath {
+			absPath, err := findAllowedRoot(allowedDirs, fp.NewPath)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := os.Stat(filepath.Dir(absPath)); os.IsNotExist(err) {
+				ops = append(ops, BatchOperation{Op: batchMkdir, Path: filepath.Dir(absPath)})
+			}
+		}
+		switch fp.operation() {
+		case "create", "modify":
+			ops = append(ops, BatchOperation{Op: batchWrite, Path: fp.NewPath, Content: pf.content})
+		case "rename":
+			// Moving the file first keeps its mode, owner and times; the
+			// write then keeps its line endings and byte order mark.
+			ops = append(ops,
+				BatchOperation{Op: batchMove, Source: fp.OldPath, Destination: fp.NewPath},
+				BatchOperation{Op: batchWrite, Path: fp.NewPath, Content: pf.content},
+			)
+		case "delete":
+			ops = append(ops, BatchOperation{Op: batchDelete, Path: fp.OldPath})
+		}
+	}
+	return ops, nil
+}
+
+// ApplyPatch applies a unified diff covering one or more files. Every hunk is
+// matched in memory first. Unless AllowPartial is set, nothing is written if
+// any hunk is rejected; otherwise files keep the hunks that applied. Changes
+// are written as one batch, so a failure while writing rolls back the rest.
+// Deleted files go to the trash.
+func ApplyPatch(params ApplyPatchParams, allowedDirs []string) (ToolResult, error) {
+	files, err := parsePatch(params.Patch)
+	if err != nil {
+		return nil, err
+	}
+	maxFuzz := defaultPatchFuzz
+	if params.Fuzz != nil {
+		maxFuzz = *params.Fuzz
+	}
+	resolvePatchTargets(files, allowedDirs)
+	var prepared []*patchedFile
+	var report []map[string]interface{}
+	allOK := true
+	byPath := map[string]*patchedFile{}
+	for _, fp := range files {
+		prev := byPath[fp.path()]
+		if prev == nil {
+			pf := preparePatchedFile(fp, allowedDirs, maxFuzz, nil)
+			report = append(report, pf.report)
+			prepared = append(prepared, &pf)
+			byPath[fp.path()] = &pf
+			continue
+		}
+		// Further sections for a file apply to what the earlier ones made
+		// of it, as with patch(1), and the file is written once.
+		if fp.operation() != "modify" || prev.patch.operation() == "delete" {
+			allOK = false
+			report = append(report, map[string]interface{}{
+				"path":      fp.path(),
+				"operation": fp.operation(),
+				"status":    "rejected",
+				"error":     "an earlier section of the patch already changes " + fp.path(),
+			})
+			continue
+		}
+		pf := preparePatchedFile(fp, allowedDirs, maxFuzz, &prev.content)
+		report = append(report, pf.report)
+		prev.merge(pf)
+	}
+	for _, pf := range prepared {
+		pf.checkPatchedSyntax()
+		if !pf.ok {
+			allOK = false
+		}
+	}
+	result := ToolResult{"ok": allOK, "files": report}
+	if !allOK {
+		result["isError"] = true
+	}
+	var toApply []*patchedFile
+	for _, pf := range prepared {
+		switch {
+		case pf.ok:
+			toApply = append(toApply, pf)
+		case params.AllowPartial && pf.applied > 0 && pf.patch.operation() == "modify":
+			pf.setStatus("partial")
+			toApply = append(toApply, pf)
+		}
+	}
+	if params.DryRun || (!allOK && !params.AllowPartial) || len(toApply) == 0 {
+		if !allOK && !params.AllowPartial && !params.DryRun {
+			result["error"] = "patch rejected; no files were changed"
+		}
+		result["dryRun"] = params.DryRun
+		return result, nil
+	}
+	ops, err := patchOperations(toApply, allowedDirs)
+	if err != nil {
+		return nil, err
+	}
+	batch, err := Batch(BatchParams{Operations: ops}, allowedDirs)
+	if err != nil {
+		return nil, err
+	}
+	if ok, _ := batch["ok"].(bool); !ok {
+		return ToolResult{
+			"ok":      false,
+			"error":   "writing the patch failed and was rolled back",
+			"files":   report,
+			"batch":   batch,
+			"isError": true,
+		}, nil
+	}
+	return result, nil
+}
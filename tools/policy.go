@@ -0,0 +1,96 @@
+package tools
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy holds server-wide settings that control how mutating tools behave.
+// It is configured once at startup from command-line flags.
+type Policy struct {
+	// AllowPermanentDelete permits delete_file calls with permanent: true,
+	// which bypass the trash.
+	AllowPermanentDelete bool
+	// TrashMaxAge is how long trashed entries are kept. Zero keeps them forever.
+	TrashMaxAge time.Duration
+	// TrashMaxSize caps the total size in bytes of each root's trash. When it is
+	// exceeded the oldest entries are purged first. Zero means no limit.
+	TrashMaxSize int64
+	// MaxDeleteEntries caps how many files and directories a single delete may
+	// remove. Zero means no limit.
+	MaxDeleteEntries int
+	// AllowChown permits set_owner to change file ownership.
+	AllowChown bool
+	// SyntaxCheckExtensions lists the file extensions (".go", ".json",
+	// ".xml") whose content is parsed before write_file, edit_file and
+	// apply_patch write it. Files that fail to parse are refused.
+	SyntaxCheckExtensions []string
+	// SyntaxCheckWarnOnly writes files that fail the syntax check anyway and
+	// reports the parse error as a warning.
+	SyntaxCheckWarnOnly bool
+	// FormatOnWriteExtensions lists the file extensions that write_file and
+	// edit_file run through format_file's formatter before writing.
+	FormatOnWriteExtensions []string
+	// ReadOnlyRoots lists allowed directories that tools may read but not
+	// change.
+	ReadOnlyRoots []string
+}
+
+// ParseReadOnlyRoots parses a comma-separated list of directories that must
+// each be one of allowedDirs.
+func ParseReadOnlyRoots(list string, allowedDirs []string) ([]string, error) {
+	var roots []string
+	for _, dir := range strings.Split(list, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		abs, err := filepath.Abs(filepath.Clean(dir))
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, allowed := range allowedDirs {
+			if a, err := filepath.Abs(filepath.Clean(allowed)); err == nil && a == abs {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not an allowed directory", dir)
+		}
+		roots = append(roots, dir)
+	}
+	return roots, nil
+}
+
+// DefaultPolicy returns the policy used when none has been configured.
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowPermanentDelete: false,
+		TrashMaxAge:          7 * 24 * time.Hour,
+		TrashMaxSize:         1 << 30,
+		MaxDeleteEntries:     10000,
+	}
+}
+
+var (
+	policyMu      sync.RWMutex
+	currentPolicy = DefaultPolicy()
+)
+
+// SetPolicy replaces the active policy.
+func SetPolicy(p Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	currentPolicy = p
+}
+
+// CurrentPolicy returns a copy of the active policy.
+func CurrentPolicy() Policy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return currentPolicy
+}
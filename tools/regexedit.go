@@ -0,0 +1,75 @@
+package tools
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// defaultMaxReplacements caps regex edits that don't set MaxReplacements.
+const defaultMaxReplacements = 1000
+
+// compileEditRegex compiles pattern with the requested flags prepended.
+func compileEditRegex(pattern string, multiline, caseInsensitive bool) (*regexp.Regexp, error) {
+	flags := ""
+	if multiline {
+		flags += "m"
+	}
+	if caseInsensitive {
+		flags += "i"
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex: %v", err)
+	}
+	return re, nil
+}
+
+// regexMatches returns every match of re in content with its expanded
+// replacement.
+func regexMatches(content string, re *regexp.Regexp, template string) []textMatch {
+	var matches []textMatch
+	for _, loc := range re.FindAllStringSubmatchIndex(content, -1) {
+		text := string(re.ExpandString(nil, template, content, loc))
+		matches = append(matches, textMatch{Start: loc[0], End: loc[1], Text: text})
+	}
+	return matches
+}
+
+// applyRegexEdit replaces the matches of a regex edit. Several matches need
+// ReplaceAll or Occurrence, as with literal edits.
+func applyRegexEdit(content string, edit EditOperation) (string, []editHunk, matchInfo, error) {
+	info := matchInfo{Normalization: "none", Confidence: 1}
+	re, err := compileEditRegex(edit.OldText, edit.Multiline, edit.CaseInsensitive)
+	if err != nil {
+		return "", nil, info, err
+	}
+	candidates := regexMatches(content, re, normalizeText([]byte(edit.NewText)))
+	if len(candidates) == 0 {
+		return "", nil, info, fmt.Errorf("regex %q matches nothing", edit.OldText)
+	}
+	matches, err := selectMatches(content, candidates, edit)
+	if err != nil {
+		return "", nil, info, err
+	}
+	limit := edit.MaxReplacements
+	if limit <= 0 {
+		limit = defaultMaxReplacements
+	}
+	if len(matches) > limit {
+		return "", nil, info, fmt.Errorf("regex matches %d times, more than maxReplacements %d", len(matches), limit)
+	}
+	info.Replacements = len(matches)
+	info.Matches = []map[string]interface{}{}
+	for _, m := range matches {
+		info.Matches = append(info.Matches, map[string]interface{}{
+			"line":        lineAt(content, m.Start),
+			"match":       content[m.Start:m.End],
+			"replacement": m.Text,
+		})
+	}
+	updated, hunks := replaceAt(content, matches)
+	return updated, hunks, info, nil
+}
@@ -0,0 +1,263 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+type ReplaceInFilesParams struct {
+	Path             string            `json:"path" description:"Directory to search" required:"true"`
+	Include          []string          `json:"include" description:"Globs of files to include"`
+	Exclude          []string          `json:"exclude" description:"Globs of files and directories to skip"`
+	Pattern          string            `json:"pattern" description:"Text or regular expression to find" required:"true"`
+	Replacement      string            `json:"replacement" description:"Replacement; with regex it may use $1 or ${name}" required:"true"`
+	Regex            bool              `json:"regex" description:"Treat pattern as a Go regular expression"`
+	CaseInsensitive  bool              `json:"caseInsensitive" description:"Regex: ignore case"`
+	Multiline        bool              `json:"multiline" description:"Regex: ^ and $ match at line boundaries"`
+	MaxReplacements  int               `json:"maxReplacements" description:"Fail if more replacements would be made in total" default:"1000"`
+	DryRun           bool              `json:"dryRun" description:"Return diffs without writing"`
+	ContextLines     *int              `json:"contextLines" description:"Unchanged lines shown around each change in the diffs" default:"3"`
+	ExpectedHashes   map[string]string `json:"expectedHashes" description:"Hashes from a dry run by relative path; the call fails if any file changed since"`
+	RespectGitignore *bool             `json:"respectGitignore" description:"Skip paths ignored by .gitignore, .git/info/exclude and .mcpignore files, and .git directories" default:"true"`
+}
+
+// plannedReplacement is the new content of one file and the hash of the
+// content it was computed from.
+type plannedReplacement struct {
+	absPath      string
+	rel          string
+	hash         string
+	replacements int
+	content      string
+	diff         string
+}
+
+// planReplacements walks the tree and computes every file's new content
+// without writing anything.
+func planReplacements(params ReplaceInFilesParams, allowedDirs []string) ([]plannedReplacement, error) {
+	if params.Pattern == "" {
+		return nil, errors.New("pattern is required")
+	}
+	startDir, err := findWritableRoot(allowedDirs, params.Path)
+	if err != nil {
+		return nil, err
+	}
+	var match func(content string) []textMatch
+	replacement := normalizeText([]byte(params.Replacement))
+	if params.Regex {
+		re, err := compileEditRegex(params.Pattern, params.Multiline, params.CaseInsensitive)
+		if err != nil {
+			return nil, err
+		}
+		match = func(content string) []textMatch { return regexMatches(content, re, replacement) }
+	} else {
+		if params.CaseInsensitive || params.Multiline {
+			return nil, errors.New("caseInsensitive and multiline require regex")
+		}
+		pattern := normalizeText([]byte(params.Pattern))
+		match = func(content string) []textMatch {
+			var matches []textMatch
+			for _, off := range findOccurrences(content, pattern) {
+				matches = append(matches, textMatch{Start: off, End: off + len(pattern), Text: replacement})
+			}
+			return matches
+		}
+	}
+	limit := params.MaxReplacements
+	if limit <= 0 {
+		limit = defaultMaxReplacements
+	}
+	context := contextLines(params.ContextLines)
+
+	var plan []plannedReplacement
+	total := 0
+	err = walkFiles(startDir, allowedDirs, params.Include, params.Exclude, ignoreTreeFor(allowedDirs, startDir, respectGitignore(params.RespectGitignore, true)), func(path, rel string, info fs.FileInfo) error {
+		data, ok, err := readTextFile(path, info)
+		if err != nil || !ok {
+			return nil
+		}
+		text := normalizeText(data)
+		matches := match(text)
+		if len(matches) == 0 {
+			return nil
+		}
+		updated, _ := replaceAt(text, matches)
+		if updated == text {
+			return nil
+		}
+		total += len(matches)
+		if total > limit {
+			return fmt.Errorf("more than maxReplacements %d replacements", limit)
+		}
+		oldName, newName := diffNames(allowedDirs, path)
+		plan = append(plan, plannedReplacement{
+			absPath:      path,
+			rel:          rel,
+			hash:         contentHash(data),
+			replacements: len(matches),
+			content:      updated,
+			diff:         unifiedDiff(oldName, newName, text, updated, context),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(plan, func(i, j int) bool { return plan[i].rel < plan[j].rel })
+	return plan, nil
+}
+
+// ReplaceInFilesPlan is the outcome of planning a replace_in_files call:
+// every file's new content, computed once and applied later.
+type ReplaceInFilesPlan struct {
+	params ReplaceInFilesParams
+	files  []plannedReplacement
+}
+
+// PlanReplaceInFiles computes the changes of a replace_in_files call without
+// writing anything.
+func PlanReplaceInFiles(params ReplaceInFilesParams, allowedDirs []string) (*ReplaceInFilesPlan, error) {
+	files, err := planReplacements(params, allowedDirs)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplaceInFilesPlan{params: params, files: files}, nil
+}
+
+// Paths returns the files the plan changes, so they can be journaled before
+// it is applied.
+func (p *ReplaceInFilesPlan) Paths() []string {
+	paths := make([]string, len(p.files))
+	for i, f := range p.files {
+		paths[i] = f.absPath
+	}
+	return paths
+}
+
+// ReplaceInFiles replaces a literal or regex pattern in every text file below
+// Path that matches Include and not Exclude. It plans and applies in one go;
+// see Apply.
+func ReplaceInFiles(params ReplaceInFilesParams, allowedDirs []string) (ToolResult, error) {
+	plan, err := PlanReplaceInFiles(params, allowedDirs)
+	if err != nil {
+		return nil, err
+	}
+	return plan.Apply(allowedDirs)
+}
+
+// Apply carries out the plan. A dry run returns each file's diff and hash.
+// Otherwise every file is checked to still have the hash it was planned
+// from, and against ExpectedHashes when given (e.g. from the dry run),
+// before all files are written. If one write fails, the files already
+// written are restored.
+func (p *ReplaceInFilesPlan) Apply(allowedDirs []string) (ToolResult, error) {
+	params, plan := p.params, p.files
+	files := []map[string]interface{}{}
+	total := 0
+	for _, p := range plan {
+		files = append(files, map[string]interface{}{
+			"path":         p.rel,
+			"replacements": p.replacements,
+			"hash":         p.hash,
+			"diff":         p.diff,
+		})
+		total += p.replacements
+	}
+	result := ToolResult{"files": files, "filesChanged": len(plan), "replacements": total}
+	if params.DryRun {
+		result["ok"] = true
+		result["dryRun"] = true
+		return result, nil
+	}
+
+	var stale []string
+	planned := map[string]bool{}
+	for _, p := range plan {
+		planned[p.rel] = true
+		data, err := os.ReadFile(p.absPath)
+		if err != nil || contentHash(data) != p.hash {
+			stale = append(stale, p.rel)
+			continue
+		}
+		// With ExpectedHashes, files the preview didn't include are stale too.
+		if want, ok := params.ExpectedHashes[p.rel]; params.ExpectedHashes != nil && (!ok || checkHash(data, want) != nil) {
+			stale = append(stale, p.rel)
+		}
+	}
+	for rel := range params.ExpectedHashes {
+		if !planned[rel] {
+			stale = append(stale, rel)
+		}
+	}
+	if len(stale) > 0 {
+		sort.Strings(stale)
+		return ToolResult{
+			"ok":      false,
+			"error":   "files changed since they were read: " + strings.Join(stale, ", "),
+			"stale":   stale,
+			"isError": true,
+		}, nil
+	}
+	if len(plan) == 0 {
+		result["ok"] = true
+		return result, nil
+	}
+	id, err := newTrashID()
+	if err != nil {
+		return nil, err
+	}
+	run := &batchRun{allowedDirs: allowedDirs, id: id, stashes: map[string]string{}}
+	defer run.cleanup()
+	for i, p := range plan {
+		res, err := run.writePlanned(i, p)
+		if err == nil {
+			if warning, ok := res["syntaxWarning"]; ok {
+				files[i]["syntaxWarning"] = warning
+			}
+			continue
+		}
+		failure := ToolResult{
+			"ok":         false,
+			"error":      fmt.Sprintf("writing %s failed and was rolled back: %v", p.rel, err),
+			"rolledBack": true,
+			"isError":    true,
+		}
+		for j := len(run.undo) - 1; j >= 0; j-- {
+			if uerr := run.undo[j](); uerr != nil {
+				failure["error"] = fmt.Sprintf("writing %s failed and rolling back failed too: %v", p.rel, uerr)
+				failure["rolledBack"] = false
+			}
+		}
+		return failure, nil
+	}
+	result["ok"] = true
+	return result, nil
+}
+
+// writePlanned writes the planned content of one file in its own line
+// endings. Only the syntax gate runs: format-on-write would make the written
+// bytes differ from the diff the caller approved.
+func (b *batchRun) writePlanned(index int, p plannedReplacement) (ToolResult, error) {
+	info, err := os.Stat(p.absPath)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := os.ReadFile(p.absPath)
+	if err != nil {
+		return nil, err
+	}
+	undo, err := b.backupFile(p.absPath, index)
+	if err != nil {
+		return nil, err
+	}
+	res, err := writeChecked(p.absPath, encodeText(p.content, detectTextStyle(existing)), info.Mode().Perm())
+	if err != nil {
+		return nil, err
+	}
+	b.undo = append(b.undo, undo)
+	return res, nil
+}
@@ -0,0 +1,284 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Tool input schemas are generated from the tools.*Params structs. Each
+// field's JSON name comes from its json tag; the description, required, enum
+// and default tags fill in the rest of its property schema.
+
+var schemaCache sync.Map // reflect.Type -> map[string]interface{}
+
+// schemaFor returns the JSON Schema describing values of type t.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	if s, ok := schemaCache.Load(t); ok {
+		return s.(map[string]interface{})
+	}
+	s := typeSchema(t)
+	schemaCache.Store(t, s)
+	return s
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		// Any JSON value.
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := addFieldSchemas(t, properties, nil)
+	s := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// addFieldSchemas adds the properties of t's fields to properties and
+// returns required with the required ones appended. The fields of embedded
+// structs without a json name are promoted, as encoding/json does.
+func addFieldSchemas(t reflect.Type, properties map[string]interface{}, required []string) []string {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && f.Tag.Get("json") == "" {
+			required = addFieldSchemas(f.Type, properties, required)
+			continue
+		}
+		name := jsonName(f)
+		if name == "" {
+			continue
+		}
+		prop := typeSchema(f.Type)
+		if d := f.Tag.Get("description"); d != "" {
+			prop["description"] = d
+		}
+		if e := f.Tag.Get("enum"); e != "" {
+			prop["enum"] = strings.Split(e, ",")
+		}
+		if d, ok := f.Tag.Lookup("default"); ok {
+			prop["default"] = defaultValue(prop["type"], d)
+		}
+		if f.Tag.Get("required") == "true" {
+			required = append(required, name)
+		}
+		properties[name] = prop
+	}
+	return required
+}
+
+// jsonName is the name encoding/json uses for f, or "" if f is not encoded.
+func jsonName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return ""
+	}
+	tag := f.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// defaultValue converts a default tag to the property's JSON type.
+func defaultValue(typ interface{}, s string) interface{} {
+	switch typ {
+	case "integer":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+	return s
+}
+
+// InputSchema returns the JSON Schema for a tool whose parameters are
+// decoded into params, a zero value of its parameter struct.
+func InputSchema(params interface{}) (json.RawMessage, error) {
+	return json.Marshal(schemaFor(reflect.TypeOf(params)))
+}
+
+// ValidateArgs checks decoded tool arguments against the schema of params.
+// Unknown parameters, wrong types, values outside an enum and missing
+// required parameters are reported with the name of the parameter. An empty
+// string is accepted for an optional enum parameter.
+func ValidateArgs(args interface{}, params interface{}) error {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	obj, ok := args.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("arguments must be an object, got %s", jsonType(args))
+	}
+	t := reflect.TypeOf(params)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return validateObject(obj, schemaFor(t), "")
+}
+
+// validateValue checks a decoded JSON value against schema. Null is treated
+// as an absent value. path names the value in error messages.
+func validateValue(v interface{}, schema map[string]interface{}, path string) error {
+	if v == nil {
+		return nil
+	}
+	switch schema["type"] {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return typeError(path, "a string", v)
+		}
+		if enum, ok := schema["enum"].([]string); ok && !containsString(enum, s) {
+			return fmt.Errorf("parameter %q must be one of %s, got %q", path, strings.Join(enum, ", "), s)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return typeError(path, "a boolean", v)
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != math.Trunc(f) {
+			return typeError(path, "an integer", v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return typeError(path, "a number", v)
+		}
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return typeError(path, "an array", v)
+		}
+		itemSchema, _ := schema["items"].(map[string]interface{})
+		for i, item := range items {
+			if err := validateValue(item, itemSchema, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return typeError(path, "an object", v)
+		}
+		return validateObject(obj, schema, path)
+	}
+	return nil
+}
+
+func validateObject(obj map[string]interface{}, schema map[string]interface{}, path string) error {
+	properties, _ := schema["properties"].(map[string]interface{})
+	extra, _ := schema["additionalProperties"].(map[string]interface{})
+	required, _ := schema["required"].([]string)
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		name := joinPath(path, k)
+		prop, ok := properties[k].(map[string]interface{})
+		if !ok {
+			if extra == nil {
+				return fmt.Errorf("unknown parameter %q", name)
+			}
+			prop = extra
+		}
+		// Handlers take an empty optional enum value as its default.
+		if _, isEnum := prop["enum"]; isEnum && obj[k] == "" && !containsString(required, k) {
+			continue
+		}
+		if err := validateValue(obj[k], prop, name); err != nil {
+			return err
+		}
+	}
+	for _, k := range required {
+		if obj[k] == nil {
+			return fmt.Errorf("missing required parameter %q", joinPath(path, k))
+		}
+	}
+	return nil
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func typeError(path, want string, v interface{}) error {
+	return fmt.Errorf("parameter %q must be %s, got %s", path, want, jsonType(v))
+}
+
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package tools
+
+import (
+	"io/fs"
+	"syscall"
+	"time"
+)
+
+// statDetails returns the owner, access time and status change time of an
+// entry. Ownership is -1 and the change time zero when they are unknown.
+func statDetails(info fs.FileInfo) (uid, gid int, atime, ctime time.Time) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1, info.ModTime(), time.Time{}
+	}
+	return int(st.Uid), int(st.Gid), time.Unix(st.Atim.Unix()), time.Unix(st.Ctim.Unix())
+}
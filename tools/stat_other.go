@@ -0,0 +1,12 @@
+//go:build !linux
+
+package tools
+
+import (
+	"io/fs"
+	"time"
+)
+
+func statDetails(info fs.FileInfo) (uid, gid int, atime, ctime time.Time) {
+	return -1, -1, info.ModTime(), time.Time{}
+}
@@ -0,0 +1,365 @@
+package tools
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashDirName is the per-root directory that holds deleted entries. Each
+// entry lives in its own subdirectory with the deleted item and an info file.
+const trashDirName = ".mcp-trash"
+
+const (
+	trashItemName = "item"
+	trashInfoName = "info.json"
+)
+
+// TrashEntry describes one deleted item kept in a root's trash.
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	Root         string    `json:"root"`
+	OriginalPath string    `json:"originalPath"`
+	DeletedAt    time.Time `json:"deletedAt"`
+	IsDir        bool      `json:"isDir"`
+	Size         int64     `json:"size"`
+}
+
+// rootFor returns the allowed root that contains absPath, preferring the
+// most specific one when roots are nested.
+func rootFor(allowedDirs []string, absPath string) (string, error) {
+	best := ""
+	for _, root := range allowedDirs {
+		cleanRoot, err := filepath.Abs(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		if absPath == cleanRoot || strings.HasPrefix(absPath, cleanRoot+string(os.PathSeparator)) {
+			if len(cleanRoot) > len(best) {
+				best = cleanRoot
+			}
+		}
+	}
+	if best == "" {
+		return "", errors.New("access outside of allowed directories is not allowed")
+	}
+	return best, nil
+}
+
+func trashDir(root string) string {
+	return filepath.Join(root, trashDirName)
+}
+
+// isInTrash reports whether absPath is the trash directory of root or lies inside it.
+func isInTrash(root, absPath string) bool {
+	dir := trashDir(root)
+	return absPath == dir || strings.HasPrefix(absPath, dir+string(os.PathSeparator))
+}
+
+func newTrashID() (string, error) {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(b[:]), nil
+}
+
+// treeSize returns the total size of regular files under path.
+func treeSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// errTrashAccess is returned for paths inside a trash directory, which only
+// the trash tools may touch.
+var errTrashAccess = errors.New("access to the trash directory is not allowed; use list_trash, restore_from_trash or empty_trash")
+
+// isTrashDir reports whether absPath is the trash directory of an allowed
+// root, for listings that hide it.
+func isTrashDir(allowedDirs []string, absPath string) bool {
+	if filepath.Base(absPath) != trashDirName {
+		return false
+	}
+	root, err := rootFor(allowedDirs, filepath.Dir(absPath))
+	return err == nil && root == filepath.Dir(absPath)
+}
+
+// moveToTrash moves absPath into the trash of its root and records where it
+// came from. The trash is purged according to the active policy afterwards.
+func moveToTrash(root, absPath string) (TrashEntry, error) {
+	info, err := os.Lstat(absPath)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	size := treeSize(absPath)
+	id, err := newTrashID()
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	entryDir := filepath.Join(trashDir(root), id)
+	if err := os.MkdirAll(entryDir, 0700); err != nil {
+		return TrashEntry{}, err
+	}
+	entry := TrashEntry{
+		ID:           id,
+		Root:         root,
+		OriginalPath: absPath,
+		DeletedAt:    time.Now().UTC(),
+		IsDir:        info.IsDir(),
+		Size:         size,
+	}
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		os.RemoveAll(entryDir)
+		return TrashEntry{}, err
+	}
+	if err := os.WriteFile(filepath.Join(entryDir, trashInfoName), b, 0600); err != nil {
+		os.RemoveAll(entryDir)
+		return TrashEntry{}, err
+	}
+	if _, err := moveEntry(absPath, filepath.Join(entryDir, trashItemName), false); err != nil {
+		os.RemoveAll(entryDir)
+		return TrashEntry{}, err
+	}
+	purgeTrash(root, CurrentPolicy(), time.Now())
+	return entry, nil
+}
+
+// readTrash returns the entries in root's trash, newest first.
+func readTrash(root string) ([]TrashEntry, error) {
+	dirs, err := os.ReadDir(trashDir(root))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []TrashEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(trashDir(root), d.Name(), trashInfoName))
+		if err != nil {
+			continue
+		}
+		var entry TrashEntry
+		if err := json.Unmarshal(b, &entry); err != nil || entry.ID != d.Name() {
+			continue
+		}
+		entry.Root = root
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// purgeTrash drops entries older than the policy's maximum age, then the
+// oldest remaining entries until the trash fits the size limit. The newest
+// entry is never dropped for size, so an item larger than the limit stays
+// until it ages out or a later delete evicts it.
+func purgeTrash(root string, p Policy, now time.Time) []string {
+	entries, err := readTrash(root)
+	if err != nil {
+		return nil
+	}
+	var purged []string
+	var kept []TrashEntry
+	for _, entry := range entries {
+		if p.TrashMaxAge > 0 && now.Sub(entry.DeletedAt) > p.TrashMaxAge {
+			if os.RemoveAll(filepath.Join(trashDir(root), entry.ID)) == nil {
+				purged = append(purged, entry.ID)
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if p.TrashMaxSize > 0 {
+		var total int64
+		for _, entry := range kept {
+			total += entry.Size
+		}
+		// kept is newest first, so evict from the end.
+		for i := len(kept) - 1; i > 0 && total > p.TrashMaxSize; i-- {
+			if os.RemoveAll(filepath.Join(trashDir(root), kept[i].ID)) == nil {
+				purged = append(purged, kept[i].ID)
+				total -= kept[i].Size
+			}
+		}
+	}
+	return purged
+}
+
+// findTrashEntry looks up an entry by ID across all allowed roots.
+func findTrashEntry(allowedDirs []string, id string) (TrashEntry, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return TrashEntry{}, fmt.Errorf("invalid trash id: %q", id)
+	}
+	for _, root := range allowedDirs {
+		cleanRoot, err := filepath.Abs(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		entries, err := readTrash(cleanRoot)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.ID == id {
+				return entry, nil
+			}
+		}
+	}
+	return TrashEntry{}, fmt.Errorf("trash entry not found: %s", id)
+}
+
+type ListTrashParams struct {
+	Path string `json:"path" description:"Limit the listing to the root containing this path"`
+}
+
+// ListTrash returns trashed entries for every allowed root, or only for the
+// root containing Path when it is set.
+func ListTrash(params ListTrashParams, allowedDirs []string) (ToolResult, error) {
+	roots := allowedDirs
+	if params.Path != "" {
+		absPath, err := findAllowedRoot(allowedDirs, params.Path)
+		if err != nil {
+			return nil, err
+		}
+		root, err := rootFor(allowedDirs, absPath)
+		if err != nil {
+			return nil, err
+		}
+		roots = []string{root}
+	}
+	entries := []TrashEntry{}
+	var totalSize int64
+	for _, root := range roots {
+		cleanRoot, err := filepath.Abs(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		purgeTrash(cleanRoot, CurrentPolicy(), time.Now())
+		rootEntries, err := readTrash(cleanRoot)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range rootEntries {
+			totalSize += entry.Size
+		}
+		entries = append(entries, rootEntries...)
+	}
+	return ToolResult{"entries": entries, "totalSize": totalSize}, nil
+}
+
+type RestoreFromTrashParams struct {
+	ID          string `json:"id" description:"Trash entry id from list_trash" required:"true"`
+	Destination string `json:"destination" description:"Alternative restore path"`
+}
+
+// RestoreFromTrash moves a trashed entry back to its original path, or to
+// Destination when given. It fails if the target already exists.
+func RestoreFromTrash(params RestoreFromTrashParams, allowedDirs []string) (ToolResult, error) {
+	entry, err := findTrashEntry(allowedDirs, params.ID)
+	if err != nil {
+		return nil, err
+	}
+	// The original path comes from the entry's info file, so it is checked
+	// like an explicit destination.
+	target, err := findWritablePath(allowedDirs, entry.OriginalPath, false)
+	if params.Destination != "" {
+		target, err = findWritableRoot(allowedDirs, params.Destination)
+	}
+	if err != nil {
+		return nil, err
+	}
+	root, err := rootFor(allowedDirs, target)
+	if err != nil {
+		return nil, err
+	}
+	if isInTrash(root, target) {
+		return nil, errors.New("cannot restore into the trash directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	entryDir := filepath.Join(trashDir(entry.Root), entry.ID)
+	if _, err := moveEntry(filepath.Join(entryDir, trashItemName), target, false); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(entryDir); err != nil {
+		return nil, err
+	}
+	return ToolResult{"ok": true, "path": target}, nil
+}
+
+type EmptyTrashParams struct {
+	ID        string `json:"id" description:"Trash entry id to remove"`
+	OlderThan string `json:"olderThan" description:"Only remove entries older than this duration, e.g. \"24h\""`
+}
+
+// EmptyTrash permanently removes trashed entries: a single one when ID is set,
+// those deleted longer ago than OlderThan (a Go duration), or all of them.
+func EmptyTrash(params EmptyTrashParams, allowedDirs []string) (ToolResult, error) {
+	if params.ID != "" {
+		entry, err := findTrashEntry(allowedDirs, params.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.RemoveAll(filepath.Join(trashDir(entry.Root), entry.ID)); err != nil {
+			return nil, err
+		}
+		return ToolResult{"ok": true, "removed": []string{entry.ID}}, nil
+	}
+	var olderThan time.Duration
+	if params.OlderThan != "" {
+		d, err := time.ParseDuration(params.OlderThan)
+		if err != nil {
+			return nil, fmt.Errorf("invalid olderThan: %v", err)
+		}
+		olderThan = d
+	}
+	now := time.Now()
+	removed := []string{}
+	for _, root := range allowedDirs {
+		cleanRoot, err := filepath.Abs(filepath.Clean(root))
+		if err != nil {
+			continue
+		}
+		entries, err := readTrash(cleanRoot)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if now.Sub(entry.DeletedAt) < olderThan {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(trashDir(cleanRoot), entry.ID)); err != nil {
+				return nil, err
+			}
+			removed = append(removed, entry.ID)
+		}
+	}
+	return ToolResult{"ok": true, "removed": removed}, nil
+}
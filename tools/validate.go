@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// syntaxError is a parse failure at a 1-based line and column.
+type syntaxError struct {
+	Language string
+	Line     int
+	Column   int
+	Message  string
+}
+
+func (e *syntaxError) Error() string {
+	return fmt.Sprintf("invalid %s at line %d, column %d: %s", e.Language, e.Line, e.Column, e.Message)
+}
+
+func (e *syntaxError) toMap() map[string]interface{} {
+	return map[string]interface{}{
+		"language": e.Language,
+		"line":     e.Line,
+		"column":   e.Column,
+		"message":  e.Message,
+	}
+}
+
+// syntaxCheckers parse file content by extension.
+var syntaxCheckers = map[string]func(name string, data []byte) *syntaxError{
+	".go":   checkGoSyntax,
+	".json": checkJSONSyntax,
+	".xml":  checkXMLSyntax,
+}
+
+// ParseSyntaxCheckExtensions parses a comma-separated list of extensions
+// such as ".go,.json". Extensions without a checker are rejected.
+func ParseSyntaxCheckExtensions(list string) ([]string, error) {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if _, ok := syntaxCheckers[ext]; !ok {
+			return nil, fmt.Errorf("no syntax check for %s files (supported: .go, .json, .xml)", ext)
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+func checkGoSyntax(name string, data []byte) *syntaxError {
+	_, err := parser.ParseFile(token.NewFileSet(), name, data, parser.SkipObjectResolution)
+	if err == nil {
+		return nil
+	}
+	serr := &syntaxError{Language: "Go", Message: err.Error()}
+	var list scanner.ErrorList
+	if errors.As(err, &list) && len(list) > 0 {
+		serr.Line, serr.Column, serr.Message = list[0].Pos.Line, list[0].Pos.Column, list[0].Msg
+	}
+	return serr
+}
+
+func checkJSONSyntax(name string, data []byte) *syntaxError {
+	_, err := parseJSON(bytes.TrimPrefix(data, utf8BOM))
+	var serr *syntaxError
+	if errors.As(err, &serr) {
+		return serr
+	}
+	return nil
+}
+
+func checkXMLSyntax(name string, data []byte) *syntaxError {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// Only the structure is checked, so declared encodings are not decoded.
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	root := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			if !root {
+				line, col := dec.InputPos()
+				return &syntaxError{Language: "XML", Line: line, Column: col, Message: "no root element"}
+			}
+			return nil
+		}
+		if err != nil {
+			line, col := dec.InputPos()
+			msg := err.Error()
+			var xerr *xml.SyntaxError
+			if errors.As(err, &xerr) {
+				msg = xerr.Msg
+			}
+			return &syntaxError{Language: "XML", Line: line, Column: col, Message: msg}
+		}
+		if _, ok := tok.(xml.StartElement); ok {
+			root = true
+		}
+	}
+}
+
+// checkSyntax parses data with the checker for path's extension when the
+// policy enables it. It returns nil for valid and unchecked files.
+func checkSyntax(path string, data []byte) *syntaxError {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, enabled := range CurrentPolicy().SyntaxCheckExtensions {
+		if enabled == ext {
+			return syntaxCheckers[ext](filepath.Base(path), data)
+		}
+	}
+	return nil
+}
+
+// syntaxGate checks content about to be written to path. Invalid content is
+// refused with an error, or with SyntaxCheckWarnOnly reported as a warning
+// to add to the result.
+func syntaxGate(path string, data []byte) (map[string]interface{}, error) {
+	serr := checkSyntax(path, data)
+	if serr == nil {
+		return nil, nil
+	}
+	if CurrentPolicy().SyntaxCheckWarnOnly {
+		return serr.toMap(), nil
+	}
+	return nil, fmt.Errorf("refusing to write %s: %v", filepath.Base(path), serr)
+}
@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// maxTextFileSize is the largest file content tools read whole when walking
+// a tree.
+const maxTextFileSize = 10 << 20
+
+// walkEntries calls fn for each entry below startDir whose path relative to
+// startDir matches include (everything when empty) and not exclude. Excluded
+// directories are not entered, and neither are directories in which include
+// cannot match. The trash, anything outside the allowed roots and, when
+// ignore is not nil, ignored paths are skipped. Symbolic links are reported
+// but not followed. fn may return filepath.SkipDir for a directory to leave
+// it out.
+func walkEntries(startDir string, allowedDirs []string, include, exclude globSet, ignore *ignoreTree, fn func(path, rel string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(startDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == startDir {
+			return nil // skip entries that can't be read
+		}
+		rel, _ := filepath.Rel(startDir, path)
+		rel = filepath.ToSlash(rel)
+		root, rootErr := rootFor(allowedDirs, path)
+		if rootErr != nil {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if isInTrash(root, path) || exclude.match(rel, true) || ignore.ignored(path, true) {
+				return filepath.SkipDir
+			}
+			if len(include) == 0 || include.match(rel, true) {
+				if err := fn(path, rel, d); err != nil {
+					return err
+				}
+			}
+			if len(include) > 0 && !include.mayMatchBelow(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if exclude.match(rel, false) || (len(include) > 0 && !include.match(rel, false)) || ignore.ignored(path, false) {
+			return nil
+		}
+		return fn(path, rel, d)
+	})
+}
+
+// walkFiles calls fn for each regular file below startDir selected by the
+// include and exclude globs, as walkEntries does.
+func walkFiles(startDir string, allowedDirs []string, include, exclude []string, ignore *ignoreTree, fn func(path, rel string, info fs.FileInfo) error) error {
+	inc, err := compileGlobs(include)
+	if err != nil {
+		return err
+	}
+	exc, err := compileGlobs(exclude)
+	if err != nil {
+		return err
+	}
+	return walkEntries(startDir, allowedDirs, inc, exc, ignore, func(path, rel string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		return fn(path, rel, info)
+	})
+}
+
+// isBinary guesses whether data is binary by looking for a NUL byte near the
+// start, as git does.
+func isBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// readTextFile returns the content of a text file, or ok=false for files
+// that are too large or binary.
+func readTextFile(path string, info fs.FileInfo) ([]byte, bool, error) {
+	if info.Size() > maxTextFileSize {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if isBinary(data) {
+		return nil, false, nil
+	}
+	return data, true, nil
+}